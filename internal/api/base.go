@@ -2,7 +2,9 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
 )
 
 type errResponse struct {
@@ -33,3 +35,16 @@ func (methodNotAllowedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	encoder := json.NewEncoder(w)
 	_ = encoder.Encode(errResponse{ShortDesc: "Method Not Allowed"})
 }
+
+// parseBoolQueryParam returns false if there is no such parameter in query.
+func parseBoolQueryParam(r *http.Request, name string) (bool, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return false, nil
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("query parameter %s should be boolean, got %s", name, s)
+	}
+	return v, nil
+}
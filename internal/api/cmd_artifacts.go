@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+	"mime"
+	"net/http"
+	"os"
+	"pg-test-task-2024/internal/db"
+	"pg-test-task-2024/internal/executor"
+	"time"
+)
+
+type artifactDto struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	Sha256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"created-at"`
+}
+
+type artifactListDto struct {
+	Artifacts []artifactDto `json:"artifacts"`
+}
+
+func toArtifactDto(entity db.ArtifactEntity) artifactDto {
+	return artifactDto{
+		Name:      entity.Name,
+		Size:      entity.Size,
+		Sha256:    entity.Sha256,
+		CreatedAt: entity.CreatedAt,
+	}
+}
+
+// cmdArtifactsHandler returns artifacts collected after the script exited
+func cmdArtifactsHandler(w http.ResponseWriter, r *http.Request) {
+	logger := getLogger(r)
+	encoder := json.NewEncoder(w)
+
+	s := mux.Vars(r)["id"]
+	id, err := uuid.Parse(s)
+	if err != nil {
+		logger.Printf("%s is invalid UUID: %s", s, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = encoder.Encode(errResponse{
+			ShortDesc: "Bad Request",
+			LongDesc:  fmt.Sprintf("Invalid url"),
+		})
+		return
+	}
+
+	ctx := r.Context()
+	var artifacts []db.ArtifactEntity
+	err = doTransactional(ctx, func(tx pgx.Tx) error {
+		var err error
+		artifacts, err = db.GetCommandArtifacts(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		logger.Printf("failed to get command artifacts: %s", err)
+		w.Header().Set("Content-Type", "application/json")
+		if errors.Is(err, db.ErrEntityNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			_ = encoder.Encode(errResponse{
+				ShortDesc: "Not Found",
+				LongDesc:  "Entity with such id not found",
+			})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = encoder.Encode(errResponse{
+			ShortDesc: "Internal Server Error",
+		})
+		return
+	}
+
+	rsp := artifactListDto{Artifacts: make([]artifactDto, 0, len(artifacts))}
+	for _, artifact := range artifacts {
+		rsp.Artifacts = append(rsp.Artifacts, toArtifactDto(artifact))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = encoder.Encode(rsp)
+	logger.Printf("OK")
+}
+
+// cmdArtifactHandler returns content of the artifact. ETag is SHA-256 of the content,
+// Range and conditional requests are supported.
+func cmdArtifactHandler(w http.ResponseWriter, r *http.Request) {
+	logger := getLogger(r)
+	encoder := json.NewEncoder(w)
+
+	s := mux.Vars(r)["id"]
+	id, err := uuid.Parse(s)
+	if err != nil {
+		logger.Printf("%s is invalid UUID: %s", s, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = encoder.Encode(errResponse{
+			ShortDesc: "Bad Request",
+			LongDesc:  fmt.Sprintf("Invalid url"),
+		})
+		return
+	}
+
+	ctx := r.Context()
+	var artifact db.ArtifactEntity
+	err = doTransactional(ctx, func(tx pgx.Tx) error {
+		var err error
+		artifact, err = db.GetCommandArtifact(ctx, tx, id, mux.Vars(r)["name"])
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+	var file *os.File
+	if err == nil {
+		// name is taken from db, so it can't point outside of the storage
+		file, err = os.Open(executor.ArtifactPath(id, artifact.Name))
+		if errors.Is(err, os.ErrNotExist) {
+			err = fmt.Errorf("%w: %w", db.ErrEntityNotFound, err)
+		}
+	}
+	if err != nil {
+		logger.Printf("failed to get command artifact: %s", err)
+		w.Header().Set("Content-Type", "application/json")
+		if errors.Is(err, db.ErrEntityNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			_ = encoder.Encode(errResponse{
+				ShortDesc: "Not Found",
+				LongDesc:  "Artifact with such name not found",
+			})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = encoder.Encode(errResponse{
+			ShortDesc: "Internal Server Error",
+		})
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": artifact.Name}))
+	w.Header().Set("ETag", fmt.Sprintf("%q", artifact.Sha256))
+	http.ServeContent(w, r, "", artifact.CreatedAt, file)
+	logger.Printf("OK")
+}
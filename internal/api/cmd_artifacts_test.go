@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"pg-test-task-2024/internal/config"
+	"pg-test-task-2024/internal/db"
+	"pg-test-task-2024/internal/db/dbtest"
+	"pg-test-task-2024/internal/executor"
+	"testing"
+)
+
+func TestCmdArtifacts_WithBadId(t *testing.T) {
+	for name, handler := range map[string]http.HandlerFunc{
+		"list":     cmdArtifactsHandler,
+		"download": cmdArtifactHandler,
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/cmd/not-uuid/artifacts/report", nil)
+			rr := httptest.NewRecorder()
+			req = mux.SetURLVars(req, map[string]string{
+				"id":   "not-uuid",
+				"name": "report",
+			})
+
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusBadRequest {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestCmdArtifacts_WithCmdInDB(t *testing.T) {
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+	t.Setenv("EXECUTOR_ARTIFACTS_DIR", t.TempDir())
+
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to testcontainer db: %s", err)
+	}
+	doTransactional = db.TransactionWorkerProvider(pool)
+
+	artifact := db.ArtifactEntity{
+		Name:   "report.txt",
+		Size:   7,
+		Sha256: "2e9bf8a1d4b3b1e4ef1d3b7c46b7d6b0ba2bc1b0e7b9b7b6c5e2b0b7e6a1b1c0",
+	}
+	var id uuid.UUID
+	err = doTransactional(ctx, func(tx pgx.Tx) error {
+		newId, err := db.InsertNewCommand(ctx, tx, correctScript, db.CommandOptions{})
+		if err != nil {
+			return err
+		}
+		id = newId
+		err = db.AddCommandArtifacts(ctx, tx, id, []db.ArtifactEntity{artifact})
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		t.Fatalf("failed to insert test command into test db: %s", err)
+	}
+	path := executor.ArtifactPath(id, artifact.Name)
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err == nil {
+		err = os.WriteFile(path, []byte("report\n"), 0600)
+	}
+	if err != nil {
+		t.Fatalf("failed to write artifact: %s", err)
+	}
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/cmd/%s/artifacts", id), nil)
+	rr := httptest.NewRecorder()
+	req = mux.SetURLVars(req, map[string]string{
+		"id": id.String(),
+	})
+	http.HandlerFunc(cmdArtifactsHandler).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var list artifactListDto
+	err = json.NewDecoder(rr.Body).Decode(&list)
+	if err != nil {
+		t.Fatalf("failed to decode response: %s", err)
+	}
+	if len(list.Artifacts) != 1 || list.Artifacts[0].Name != artifact.Name ||
+		list.Artifacts[0].Size != artifact.Size || list.Artifacts[0].Sha256 != artifact.Sha256 {
+		t.Fatalf("got artifacts %v", list.Artifacts)
+	}
+
+	for _, tc := range []struct {
+		name           string
+		expectedStatus int
+	}{
+		{artifact.Name, http.StatusOK},
+		{"unknown", http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/cmd/%s/artifacts/%s", id, tc.name), nil)
+			rr := httptest.NewRecorder()
+			req = mux.SetURLVars(req, map[string]string{
+				"id":   id.String(),
+				"name": tc.name,
+			})
+			http.HandlerFunc(cmdArtifactHandler).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tc.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, tc.expectedStatus)
+			}
+			if tc.expectedStatus == http.StatusOK && rr.Body.String() != "report\n" {
+				t.Fatalf("got content %q", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestCmdArtifacts_WithNoCmdInDB(t *testing.T) {
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to testcontainer db: %s", err)
+	}
+	doTransactional = db.TransactionWorkerProvider(pool)
+
+	id := uuid.New()
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/cmd/%s/artifacts", id), nil)
+	rr := httptest.NewRecorder()
+	req = mux.SetURLVars(req, map[string]string{
+		"id": id.String(),
+	})
+	http.HandlerFunc(cmdArtifactsHandler).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
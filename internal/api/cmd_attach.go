@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v4"
+	"net/http"
+	"pg-test-task-2024/internal/db"
+	"pg-test-task-2024/internal/executor"
+)
+
+var upgrader = websocket.Upgrader{}
+
+// attachMessageDto is sent to the attached client. Event is one of
+//   - output - chunk of output, stream and data are set
+//   - lagged - client did not keep up, so some output was skipped
+//   - end - command is not running anymore, status fields are set
+type attachMessageDto struct {
+	Event      string `json:"event"`
+	Stream     string `json:"stream,omitempty"`
+	Data       string `json:"data,omitempty"`
+	Status     string `json:"status,omitempty"`
+	StatusDesc string `json:"status-desc,omitempty"`
+	ExitCode   *int   `json:"exit-code,omitempty"`
+	Signal     *int   `json:"signal,omitempty"`
+}
+
+// getCommandWithoutOutput reads the command from db in separate transaction
+func getCommandWithoutOutput(ctx context.Context, id uuid.UUID) (db.CommandEntity, error) {
+	var entity db.CommandEntity
+	err := doTransactional(ctx, func(tx pgx.Tx) error {
+		var err error
+		entity, err = db.GetSingleCommandWithoutOutput(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+	return entity, err
+}
+
+// writeOutputMessage sends encoded data of the stream, nothing is sent if it is empty
+func writeOutputMessage(conn *websocket.Conn, stream db.OutputStream, data string) error {
+	if data == "" {
+		return nil
+	}
+	return conn.WriteJSON(attachMessageDto{
+		Event:  "output",
+		Stream: string(stream),
+		Data:   data,
+	})
+}
+
+// flushOutputMessages sends bytes of incomplete runes kept by the encoder,
+// when no output follows them
+func flushOutputMessages(conn *websocket.Conn, output *outputEncoder) error {
+	for _, stream := range []db.OutputStream{db.Stdout, db.Stderr} {
+		err := writeOutputMessage(conn, stream, output.flush(stream))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEndMessage sends status of the done command and closes the connection.
+// Only close message is sent if status is empty.
+func writeEndMessage(conn *websocket.Conn, entity db.CommandEntity) {
+	if entity.Status != "" {
+		_ = conn.WriteJSON(attachMessageDto{
+			Event:      "end",
+			Status:     string(entity.Status),
+			StatusDesc: entity.StatusDesc,
+			ExitCode:   entity.ExitCode,
+			Signal:     entity.Signal,
+		})
+	}
+	_ = conn.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "command is done"))
+}
+
+// cmdAttachHandler upgrades connection to WebSocket. Messages from the client are
+// written to stdin of the interactive command, output of the command is sent back.
+func cmdAttachHandler(w http.ResponseWriter, r *http.Request) {
+	logger := getLogger(r)
+	encoder := json.NewEncoder(w)
+
+	s := mux.Vars(r)["id"]
+	id, err := uuid.Parse(s)
+	if err != nil {
+		logger.Printf("%s is invalid UUID: %s", s, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = encoder.Encode(errResponse{
+			ShortDesc: "Bad Request",
+			LongDesc:  fmt.Sprintf("Invalid url"),
+		})
+		return
+	}
+
+	var encoding string
+	eofOnClose, err := parseBoolQueryParam(r, "eof-on-close")
+	if err == nil {
+		encoding, err = parseEncodingParam(r)
+	}
+	if err != nil {
+		logger.Printf("bad query: %s", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = encoder.Encode(errResponse{
+			ShortDesc: "Bad Request",
+			LongDesc:  err.Error(),
+		})
+		return
+	}
+
+	// subscribe before attaching, so Done event is not missed if the command exits in between:
+	// the command can't be attached after it is not running
+	events, unsubscribe := subscribe(id)
+	defer func() {
+		unsubscribe()
+	}()
+
+	stdin, detach, err := attachStdin(id)
+	if err != nil {
+		logger.Printf("failed to attach to command: %s", err)
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case errors.Is(err, executor.ErrNotFound):
+			w.WriteHeader(http.StatusNotFound)
+			_ = encoder.Encode(errResponse{
+				ShortDesc: "Not Found",
+				LongDesc:  "Running command with such id not found",
+			})
+		case errors.Is(err, executor.ErrNotInteractive), errors.Is(err, executor.ErrAlreadyAttached):
+			w.WriteHeader(http.StatusConflict)
+			_ = encoder.Encode(errResponse{
+				ShortDesc: "Conflict",
+				LongDesc:  err.Error(),
+			})
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = encoder.Encode(errResponse{
+				ShortDesc: "Internal Server Error",
+			})
+		}
+		return
+	}
+	defer detach()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// upgrader has already replied to the client
+		logger.Printf("failed to upgrade connection: %s", err)
+		return
+	}
+	defer conn.Close()
+	logger.Printf("client attached")
+
+	output := newOutputEncoder(encoding)
+	clientDone := make(chan error, 1)
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				clientDone <- err
+				return
+			}
+			_, err = stdin.Write(data)
+			if err != nil {
+				clientDone <- fmt.Errorf("failed to write stdin: %w", err)
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case err := <-clientDone:
+			logger.Printf("client detached: %s", err)
+			if eofOnClose {
+				_ = stdin.Close()
+			}
+			return
+		case event, ok := <-events:
+			if !ok {
+				unsubscribe()
+				events, unsubscribe = subscribe(id)
+				// the output after the skipped one does not continue the kept runes
+				err = flushOutputMessages(conn, output)
+				if err == nil {
+					err = conn.WriteJSON(attachMessageDto{Event: "lagged"})
+				}
+				if err != nil {
+					logger.Printf("failed to write message: %s", err)
+					return
+				}
+				// the dropped events may include the Done one, so the status is read again
+				entity, err := getCommandWithoutOutput(r.Context(), id)
+				if err != nil {
+					logger.Printf("failed to get command info: %s", err)
+				} else if entity.Status.Done() {
+					writeEndMessage(conn, entity)
+					logger.Printf("command is done")
+					return
+				}
+			} else if event.Done {
+				entity, err := getCommandWithoutOutput(r.Context(), id)
+				if err != nil {
+					logger.Printf("failed to get command info: %s", err)
+					entity = db.CommandEntity{}
+				}
+				_ = flushOutputMessages(conn, output)
+				writeEndMessage(conn, entity)
+				logger.Printf("command is done")
+				return
+			} else {
+				err = writeOutputMessage(conn, event.Stream, output.encode(event.Stream, []byte(event.Data)))
+			}
+			if err != nil {
+				logger.Printf("failed to write message: %s", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"pg-test-task-2024/internal/config"
+	"pg-test-task-2024/internal/db"
+	"pg-test-task-2024/internal/db/dbtest"
+	"pg-test-task-2024/internal/executor"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCmdAttach_WithBadUrl(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/v1/cmd/not-uuid/attach", nil)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(cmdAttachHandler)
+	req = mux.SetURLVars(req, map[string]string{
+		"id": "not-uuid",
+	})
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+	contentType := rr.Header().Get("Content-Type")
+	if contentType != "application/json" {
+		t.Fatalf("handler returned wrong content type: got %v want %v", contentType, "application/json")
+	}
+}
+
+var attachErrors = []struct {
+	err            error
+	expectedStatus int
+}{
+	{executor.ErrNotFound, http.StatusNotFound},
+	{executor.ErrNotInteractive, http.StatusConflict},
+	{executor.ErrAlreadyAttached, http.StatusConflict},
+	{fmt.Errorf("some other error"), http.StatusInternalServerError},
+}
+
+func TestCmdAttach_WithAttachError(t *testing.T) {
+	subscribe = func(id uuid.UUID) (<-chan executor.CmdEvent, func()) {
+		return make(chan executor.CmdEvent), func() {}
+	}
+	for _, tc := range attachErrors {
+		t.Run(tc.err.Error(), func(t *testing.T) {
+			attachStdin = func(id uuid.UUID) (io.WriteCloser, func(), error) {
+				return nil, nil, tc.err
+			}
+			id := uuid.New()
+
+			req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/cmd/%s/attach", id), nil)
+			req = mux.SetURLVars(req, map[string]string{
+				"id": id.String(),
+			})
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(cmdAttachHandler)
+
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tc.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, tc.expectedStatus)
+			}
+			contentType := rr.Header().Get("Content-Type")
+			if contentType != "application/json" {
+				t.Fatalf("handler returned wrong content type: got %v want %v", contentType, "application/json")
+			}
+		})
+	}
+}
+
+// stubStdin is safe for concurrent use
+type stubStdin struct {
+	mtx    sync.Mutex
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (s *stubStdin) Write(p []byte) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *stubStdin) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *stubStdin) state() (string, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.buf.String(), s.closed
+}
+
+func TestCmdAttach_ForwardsStdinAndOutput(t *testing.T) {
+	stdin := &stubStdin{}
+	detached := make(chan struct{})
+	attachStdin = func(id uuid.UUID) (io.WriteCloser, func(), error) {
+		return stdin, func() { close(detached) }, nil
+	}
+	events := make(chan executor.CmdEvent, 1)
+	subscribe = func(id uuid.UUID) (<-chan executor.CmdEvent, func()) {
+		return events, func() {}
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/cmd/{id}/attach", cmdAttachHandler)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + fmt.Sprintf("/api/v1/cmd/%s/attach?eof-on-close=true", uuid.New())
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+
+	err = conn.WriteMessage(websocket.TextMessage, []byte("yes\n"))
+	if err != nil {
+		t.Fatalf("failed to write message: %s", err)
+	}
+
+	events <- executor.CmdEvent{Stream: db.Stdout, Data: "continue? ", End: 10}
+	var msg attachMessageDto
+	err = conn.ReadJSON(&msg)
+	if err != nil {
+		t.Fatalf("failed to read message: %s", err)
+	}
+	if msg.Event != "output" || msg.Stream != string(db.Stdout) || msg.Data != "continue? " {
+		t.Fatalf("got unexpected message %v", msg)
+	}
+
+	_ = conn.Close()
+	select {
+	case <-detached:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("handler did not detach after client closed connection")
+	}
+
+	written, closed := stdin.state()
+	if written != "yes\n" {
+		t.Fatalf("stdin does not match: got %q, expected %q", written, "yes\n")
+	}
+	if !closed {
+		t.Fatalf("expected stdin to be closed")
+	}
+}
+
+func TestCmdAttach_FinishesWhenCmdExitsWhileAttaching(t *testing.T) {
+	// like the output hub, Done event is published only to existing subscriptions
+	var events chan executor.CmdEvent
+	subscribe = func(id uuid.UUID) (<-chan executor.CmdEvent, func()) {
+		events = make(chan executor.CmdEvent, 1)
+		return events, func() {}
+	}
+	attachStdin = func(id uuid.UUID) (io.WriteCloser, func(), error) {
+		if events != nil {
+			events <- executor.CmdEvent{Done: true}
+		}
+		return &stubStdin{}, func() {}, nil
+	}
+	doTransactional = func(ctx context.Context, worker func(tx pgx.Tx) error) error {
+		return errors.New("db is down")
+	}
+	t.Cleanup(func() {
+		doTransactional = nil
+	})
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/cmd/{id}/attach", cmdAttachHandler)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + fmt.Sprintf("/api/v1/cmd/%s/attach", uuid.New())
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Fatalf("got %v, expected connection to be closed as command is done", err)
+	}
+}
+
+func TestCmdAttach_KeepsRuneSplitBetweenChunks(t *testing.T) {
+	attachStdin = func(id uuid.UUID) (io.WriteCloser, func(), error) {
+		return &stubStdin{}, func() {}, nil
+	}
+	events := make(chan executor.CmdEvent, 2)
+	subscribe = func(id uuid.UUID) (<-chan executor.CmdEvent, func()) {
+		return events, func() {}
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/cmd/{id}/attach", cmdAttachHandler)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + fmt.Sprintf("/api/v1/cmd/%s/attach", uuid.New())
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// "ü" is split between the chunks
+	events <- executor.CmdEvent{Stream: db.Stdout, Data: "gr\xc3", End: 3}
+	events <- executor.CmdEvent{Stream: db.Stdout, Data: "\xbc\xc3\x9fe\n", End: 8}
+	for _, expected := range []string{"gr", "üße\n"} {
+		var msg attachMessageDto
+		err = conn.ReadJSON(&msg)
+		if err != nil {
+			t.Fatalf("failed to read message: %s", err)
+		}
+		if msg.Event != "output" || msg.Stream != string(db.Stdout) || msg.Data != expected {
+			t.Fatalf("got message %v, expected output %q", msg, expected)
+		}
+	}
+}
+
+func TestCmdAttach_FinishesWhenDoneEventIsDropped(t *testing.T) {
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to testcontainer db: %s", err)
+	}
+	doTransactional = db.TransactionWorkerProvider(pool)
+
+	var id uuid.UUID
+	err = doTransactional(ctx, func(tx pgx.Tx) error {
+		id, err = db.InsertNewCommand(ctx, tx, correctScript, db.CommandOptions{Interactive: true})
+		if err != nil {
+			return err
+		}
+		// only running command may fail
+		_, err = db.DequeueCommand(ctx, tx)
+		if err != nil {
+			return err
+		}
+		err = db.SetCommandFailed(ctx, tx, id, "failed")
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		t.Fatalf("failed to insert test command into test db: %s", err)
+	}
+
+	attachStdin = func(id uuid.UUID) (io.WriteCloser, func(), error) {
+		return &stubStdin{}, func() {}, nil
+	}
+	// the first subscription lags and its Done event is dropped,
+	// the second one gets nothing, as the command is already done
+	lagged := make(chan executor.CmdEvent)
+	close(lagged)
+	subscriptions := []chan executor.CmdEvent{lagged, make(chan executor.CmdEvent)}
+	subscribe = func(id uuid.UUID) (<-chan executor.CmdEvent, func()) {
+		events := subscriptions[0]
+		subscriptions = subscriptions[1:]
+		return events, func() {}
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/cmd/{id}/attach", cmdAttachHandler)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + fmt.Sprintf("/api/v1/cmd/%s/attach", id)
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	for _, expected := range []string{"lagged", "end"} {
+		var msg attachMessageDto
+		err = conn.ReadJSON(&msg)
+		if err != nil {
+			t.Fatalf("failed to read %s message: %s", expected, err)
+		}
+		if msg.Event != expected {
+			t.Fatalf("got message %v, expected event %s", msg, expected)
+		}
+		if expected == "end" && (msg.Status != string(db.Error) || msg.StatusDesc != "failed") {
+			t.Fatalf("got end message %v", msg)
+		}
+	}
+}
@@ -7,9 +7,53 @@ import (
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"net/http"
+	"pg-test-task-2024/internal/config"
 	"pg-test-task-2024/internal/executor"
+	"syscall"
+	"time"
 )
 
+// resolveGracePeriod returns grace period considering server default and max grace periods.
+// Empty s means that grace period was not requested. Returned error is suitable for client.
+func resolveGracePeriod(s string) (time.Duration, error) {
+	maxGracePeriod := config.GetMaxGracePeriod()
+	if s == "" {
+		return min(config.GetDefaultGracePeriod(), maxGracePeriod), nil
+	}
+
+	gracePeriod, err := time.ParseDuration(s)
+	if err != nil || gracePeriod < 0 {
+		return 0, fmt.Errorf("grace-period should be non-negative duration (e.g. 10s, 1m), got %s", s)
+	}
+	if gracePeriod > maxGracePeriod {
+		return 0, fmt.Errorf("grace-period should not exceed %s", maxGracePeriod)
+	}
+	return gracePeriod, nil
+}
+
+// parseCancelRequest reads signal and grace period from the query.
+// Without signal the command is killed at once. Returned error is suitable for client.
+func parseCancelRequest(r *http.Request) (executor.CancelRequest, error) {
+	req := executor.CancelRequest{Signal: syscall.SIGKILL}
+	query := r.URL.Query()
+	if s := query.Get("signal"); s != "" {
+		sig, err := executor.ParseSignal(s)
+		if err != nil {
+			return executor.CancelRequest{}, err
+		}
+		req.Signal = sig
+	}
+
+	gracePeriod, err := resolveGracePeriod(query.Get("grace-period"))
+	if err != nil {
+		return executor.CancelRequest{}, err
+	}
+	if req.Signal != syscall.SIGKILL {
+		req.GracePeriod = gracePeriod
+	}
+	return req, nil
+}
+
 func cmdCancelHandler(w http.ResponseWriter, r *http.Request) {
 	logger := getLogger(r)
 	encoder := json.NewEncoder(w)
@@ -27,7 +71,19 @@ func cmdCancelHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = cancelById(id)
+	cancelReq, err := parseCancelRequest(r)
+	if err != nil {
+		logger.Printf("bad query: %s", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = encoder.Encode(errResponse{
+			ShortDesc: "Bad Request",
+			LongDesc:  err.Error(),
+		})
+		return
+	}
+
+	err = cancelById(id, cancelReq)
 	if err != nil {
 		logger.Printf("failed to cancel command: %s", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -36,6 +92,12 @@ func cmdCancelHandler(w http.ResponseWriter, r *http.Request) {
 			_ = encoder.Encode(errResponse{
 				ShortDesc: "Not Found",
 			})
+		} else if errors.Is(err, executor.ErrAlreadyCanceled) {
+			w.WriteHeader(http.StatusConflict)
+			_ = encoder.Encode(errResponse{
+				ShortDesc: "Conflict",
+				LongDesc:  err.Error(),
+			})
 		} else {
 			w.WriteHeader(http.StatusInternalServerError)
 			_ = encoder.Encode(errResponse{
@@ -7,7 +7,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"pg-test-task-2024/internal/executor"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestCmdCancel_WithBadUrl(t *testing.T) {
@@ -30,7 +32,7 @@ func TestCmdCancel_WithBadUrl(t *testing.T) {
 }
 
 func TestCmdCancel_WithCancelReturnsNil(t *testing.T) {
-	cancelById = func(id uuid.UUID) error {
+	cancelById = func(id uuid.UUID, req executor.CancelRequest) error {
 		return nil
 	}
 	id := uuid.New()
@@ -55,7 +57,7 @@ func TestCmdCancel_WithCancelReturnsNil(t *testing.T) {
 }
 
 func TestCmdCancel_WithCancelReturnsErrNotFound(t *testing.T) {
-	cancelById = func(id uuid.UUID) error {
+	cancelById = func(id uuid.UUID, req executor.CancelRequest) error {
 		return executor.ErrNotFound
 	}
 	id := uuid.New()
@@ -80,7 +82,7 @@ func TestCmdCancel_WithCancelReturnsErrNotFound(t *testing.T) {
 }
 
 func TestCmdCancel_WithCancelReturnsOtherError(t *testing.T) {
-	cancelById = func(id uuid.UUID) error {
+	cancelById = func(id uuid.UUID, req executor.CancelRequest) error {
 		return fmt.Errorf("some other error")
 	}
 	id := uuid.New()
@@ -103,3 +105,98 @@ func TestCmdCancel_WithCancelReturnsOtherError(t *testing.T) {
 		t.Fatalf("handler returned wrong content type: got %v want %v", contentType, "application/json")
 	}
 }
+
+func TestCmdCancel_WithCancelReturnsErrAlreadyCanceled(t *testing.T) {
+	cancelById = func(id uuid.UUID, req executor.CancelRequest) error {
+		return executor.ErrAlreadyCanceled
+	}
+	id := uuid.New()
+
+	req := httptest.NewRequest("PATCH", fmt.Sprintf("/api/v1/cmd/%s/cancel?signal=SIGINT", id.String()), nil)
+	req = mux.SetURLVars(req, map[string]string{
+		"id": id.String(),
+	})
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(cmdCancelHandler)
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusConflict {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusConflict)
+	}
+}
+
+func TestCmdCancel_WithBadQuery(t *testing.T) {
+	cancelById = func(id uuid.UUID, req executor.CancelRequest) error {
+		t.Fatalf("cancel should not be called")
+		return nil
+	}
+	id := uuid.New()
+
+	for _, query := range []string{
+		"signal=SIGSTOP",
+		"signal=15",
+		"signal=SIGTERM&grace-period=abc",
+		"signal=SIGTERM&grace-period=-1s",
+		"signal=SIGTERM&grace-period=1h",
+	} {
+		t.Run(query, func(t *testing.T) {
+			req := httptest.NewRequest("PATCH", fmt.Sprintf("/api/v1/cmd/%s/cancel?%s", id.String(), query), nil)
+			req = mux.SetURLVars(req, map[string]string{
+				"id": id.String(),
+			})
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(cmdCancelHandler)
+
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusBadRequest {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestCmdCancel_PassesCancelRequest(t *testing.T) {
+	t.Setenv("EXECUTOR_DEFAULT_GRACE_PERIOD", "15s")
+	id := uuid.New()
+
+	testCases := []struct {
+		query    string
+		expected executor.CancelRequest
+	}{
+		{"", executor.CancelRequest{Signal: syscall.SIGKILL}},
+		{"grace-period=1m", executor.CancelRequest{Signal: syscall.SIGKILL}},
+		{"signal=SIGTERM", executor.CancelRequest{Signal: syscall.SIGTERM, GracePeriod: 15 * time.Second}},
+		{"signal=int&grace-period=0s", executor.CancelRequest{Signal: syscall.SIGINT}},
+		{"signal=SIGHUP&grace-period=1m", executor.CancelRequest{Signal: syscall.SIGHUP, GracePeriod: time.Minute}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			var got executor.CancelRequest
+			cancelById = func(id uuid.UUID, req executor.CancelRequest) error {
+				got = req
+				return nil
+			}
+
+			req := httptest.NewRequest("PATCH", fmt.Sprintf("/api/v1/cmd/%s/cancel?%s", id.String(), tc.query), nil)
+			req = mux.SetURLVars(req, map[string]string{
+				"id": id.String(),
+			})
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(cmdCancelHandler)
+
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusAccepted {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusAccepted)
+			}
+			if got != tc.expected {
+				t.Fatalf("got cancel request %+v, expected %+v", got, tc.expected)
+			}
+		})
+	}
+}
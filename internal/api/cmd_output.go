@@ -0,0 +1,293 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+	"net/http"
+	"pg-test-task-2024/internal/db"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// encodings of the output in json responses
+const (
+	// textEncoding replaces invalid UTF-8 sequences with U+FFFD
+	textEncoding = "text"
+	// base64Encoding keeps exact bytes of the output
+	base64Encoding = "base64"
+)
+
+// parseEncodingParam returns requested encoding of the output, text by default
+func parseEncodingParam(r *http.Request) (string, error) {
+	switch encoding := r.URL.Query().Get("encoding"); encoding {
+	case "", textEncoding:
+		return textEncoding, nil
+	case base64Encoding:
+		return base64Encoding, nil
+	default:
+		return "", fmt.Errorf("query parameter encoding should be %s or %s, got %s",
+			textEncoding, base64Encoding, encoding)
+	}
+}
+
+// encodeOutput converts output to the string suitable for json
+func encodeOutput(output []byte, encoding string) string {
+	if encoding == base64Encoding {
+		return base64.StdEncoding.EncodeToString(output)
+	}
+	return strings.ToValidUTF8(string(output), "�")
+}
+
+// outputEncoder encodes chunks of output streams one by one. With text encoding incomplete rune
+// at the end of the chunk is kept until the next chunk of the same stream, so the rune split
+// between chunks is not replaced with U+FFFD.
+type outputEncoder struct {
+	encoding string
+	pending  map[db.OutputStream][]byte
+}
+
+func newOutputEncoder(encoding string) *outputEncoder {
+	return &outputEncoder{
+		encoding: encoding,
+		pending:  make(map[db.OutputStream][]byte),
+	}
+}
+
+// encode returns the chunk encoded with kept bytes of the stream before it.
+// Result is empty if the whole chunk is kept.
+func (e *outputEncoder) encode(stream db.OutputStream, chunk []byte) string {
+	if e.encoding == base64Encoding {
+		return encodeOutput(chunk, e.encoding)
+	}
+	data := append(e.pending[stream], chunk...)
+	n := len(data) - incompleteRuneLen(data)
+	e.pending[stream] = append([]byte(nil), data[n:]...)
+	return encodeOutput(data[:n], e.encoding)
+}
+
+// flush returns kept bytes of the stream encoded as is, when no chunk follows them
+func (e *outputEncoder) flush(stream db.OutputStream) string {
+	data := e.pending[stream]
+	delete(e.pending, stream)
+	return encodeOutput(data, e.encoding)
+}
+
+// incompleteRuneLen returns length of the incomplete UTF-8 sequence at the end of data
+func incompleteRuneLen(data []byte) int {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax+1; i-- {
+		if utf8.RuneStart(data[i]) {
+			if utf8.FullRune(data[i:]) {
+				return 0
+			}
+			return len(data) - i
+		}
+	}
+	return 0
+}
+
+// parseStreamsParam returns streams requested with stream parameter, stdout by default
+func parseStreamsParam(r *http.Request) ([]db.OutputStream, error) {
+	switch stream := r.URL.Query().Get("stream"); stream {
+	case "", string(db.Stdout):
+		return []db.OutputStream{db.Stdout}, nil
+	case string(db.Stderr):
+		return []db.OutputStream{db.Stderr}, nil
+	case "combined":
+		return []db.OutputStream{db.Stdout, db.Stderr}, nil
+	default:
+		return nil, fmt.Errorf("query parameter stream should be stdout, stderr or combined, got %s", stream)
+	}
+}
+
+// errRangeNotSatisfiable is returned if requested range is not inside the output
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// parseByteRange parses Range header with one range of bytes, e.g. bytes=0-499,
+// bytes=500- or bytes=-500. Returns start and end (exclusive) of the range inside the output of size.
+func parseByteRange(header string, size int64) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, errRangeNotSatisfiable
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, errRangeNotSatisfiable
+	}
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 1 || size == 0 {
+			return 0, 0, errRangeNotSatisfiable
+		}
+		return max(size-n, 0), size, nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, errRangeNotSatisfiable
+	}
+	end := size
+	if last != "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < start {
+			return 0, 0, errRangeNotSatisfiable
+		}
+		end = min(n+1, size)
+	}
+	return start, end, nil
+}
+
+// outputPart describes which part of the output is requested.
+// Only one of rangeHeader, tail and since may be set.
+type outputPart struct {
+	rangeHeader string
+	tail        *int
+	since       *int
+}
+
+// parseOutputPart reads requested part of the output from the request.
+// Returned error is suitable for client.
+func parseOutputPart(r *http.Request) (outputPart, error) {
+	query := r.URL.Query()
+	tail, err := parseIntParam(query, "tail")
+	if err != nil {
+		return outputPart{}, err
+	}
+	if tail != nil && *tail < 1 {
+		return outputPart{}, fmt.Errorf("query parameter tail should be positive, got %d", *tail)
+	}
+	since, err := parseIntParam(query, "since")
+	if err != nil {
+		return outputPart{}, err
+	}
+	if since != nil && *since < 0 {
+		return outputPart{}, fmt.Errorf("query parameter since should not be negative, got %d", *since)
+	}
+
+	part := outputPart{rangeHeader: r.Header.Get("Range"), tail: tail, since: since}
+	requested := 0
+	for _, set := range []bool{part.rangeHeader != "", tail != nil, since != nil} {
+		if set {
+			requested++
+		}
+	}
+	if requested > 1 {
+		return outputPart{}, errors.New("only one of Range header, tail and since may be used")
+	}
+	return part, nil
+}
+
+// cmdOutputHandler returns exact bytes of the command output or its part.
+// Header X-Next-Offset contains offset of the end of returned part,
+// it may be passed as since parameter to get output appended later.
+func cmdOutputHandler(w http.ResponseWriter, r *http.Request) {
+	logger := getLogger(r)
+	encoder := json.NewEncoder(w)
+
+	s := mux.Vars(r)["id"]
+	id, err := uuid.Parse(s)
+	if err != nil {
+		logger.Printf("%s is invalid UUID: %s", s, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = encoder.Encode(errResponse{
+			ShortDesc: "Bad Request",
+			LongDesc:  fmt.Sprintf("Invalid url"),
+		})
+		return
+	}
+
+	var part outputPart
+	streams, err := parseStreamsParam(r)
+	if err == nil {
+		part, err = parseOutputPart(r)
+	}
+	if err != nil {
+		logger.Printf("bad query: %s", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = encoder.Encode(errResponse{
+			ShortDesc: "Bad Request",
+			LongDesc:  err.Error(),
+		})
+		return
+	}
+
+	ctx := r.Context()
+	var output []byte
+	var start, end, size int64
+	err = doTransactional(ctx, func(tx pgx.Tx) error {
+		var err error
+		size, err = db.GetCommandOutputSize(ctx, tx, id, streams)
+		if err != nil {
+			return err
+		}
+		switch {
+		case part.tail != nil:
+			output, end, err = db.GetCommandOutputTail(ctx, tx, id, streams, *part.tail)
+			start = end - int64(len(output))
+		case part.rangeHeader != "":
+			start, end, err = parseByteRange(part.rangeHeader, size)
+			if err == nil {
+				output, err = db.GetCommandOutputRange(ctx, tx, id, streams, start, end)
+			}
+		default:
+			start, end = 0, size
+			if part.since != nil {
+				start = int64(*part.since)
+			}
+			if start > size {
+				return errRangeNotSatisfiable
+			}
+			output, err = db.GetCommandOutputRange(ctx, tx, id, streams, start, end)
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		logger.Printf("failed to get command output: %s", err)
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case errors.Is(err, db.ErrEntityNotFound):
+			w.WriteHeader(http.StatusNotFound)
+			_ = encoder.Encode(errResponse{
+				ShortDesc: "Not Found",
+				LongDesc:  "Entity with such id not found",
+			})
+		case errors.Is(err, errRangeNotSatisfiable):
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			_ = encoder.Encode(errResponse{
+				ShortDesc: "Range Not Satisfiable",
+				LongDesc:  fmt.Sprintf("Size of the output is %d bytes", size),
+			})
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = encoder.Encode(errResponse{
+				ShortDesc: "Internal Server Error",
+			})
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(output)))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("X-Next-Offset", strconv.FormatInt(end, 10))
+	if part.rangeHeader != "" {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, size))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	_, err = w.Write(output)
+	if err != nil {
+		logger.Printf("failed to write output: %s", err)
+		return
+	}
+	logger.Printf("OK")
+}
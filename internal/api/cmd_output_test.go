@@ -0,0 +1,394 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"net/http"
+	"net/http/httptest"
+	"pg-test-task-2024/internal/config"
+	"pg-test-task-2024/internal/db"
+	"pg-test-task-2024/internal/db/dbtest"
+	"strconv"
+	"testing"
+)
+
+func TestEncodeOutput(t *testing.T) {
+	output := []byte("ok\x00\xff\n")
+	if got := encodeOutput(output, textEncoding); got != "ok\x00�\n" {
+		t.Fatalf("got %q with text encoding", got)
+	}
+	if got := encodeOutput(output, base64Encoding); got != "b2sA/wo=" {
+		t.Fatalf("got %q with base64 encoding", got)
+	}
+}
+
+func TestOutputEncoder_KeepsSplitRunes(t *testing.T) {
+	output := newOutputEncoder(textEncoding)
+	// "é" is split between the chunks, "\xe2\x82" is never completed
+	chunks := []struct {
+		stream   db.OutputStream
+		data     string
+		expected string
+	}{
+		{db.Stdout, "caf\xc3", "caf"},
+		{db.Stderr, "err\xe2\x82", "err"},
+		{db.Stdout, "\xa9!\xff", "é!�"},
+		{db.Stdout, "\xf0\x9f\x98", ""},
+		{db.Stdout, "\x80", "😀"},
+	}
+	for _, chunk := range chunks {
+		if got := output.encode(chunk.stream, []byte(chunk.data)); got != chunk.expected {
+			t.Fatalf("got %q for chunk %q, expected %q", got, chunk.data, chunk.expected)
+		}
+	}
+	if got := output.flush(db.Stdout); got != "" {
+		t.Fatalf("got %q flushed from stdout", got)
+	}
+	if got := output.flush(db.Stderr); got != "�" {
+		t.Fatalf("got %q flushed from stderr", got)
+	}
+
+	output = newOutputEncoder(base64Encoding)
+	if got := output.encode(db.Stdout, []byte("caf\xc3")); got != "Y2Fmww==" {
+		t.Fatalf("got %q with base64 encoding", got)
+	}
+}
+
+func TestParseByteRange(t *testing.T) {
+	for _, tc := range []struct {
+		header        string
+		size          int64
+		expectedStart int64
+		expectedEnd   int64
+		expectedErr   bool
+	}{
+		{"bytes=0-4", 10, 0, 5, false},
+		{"bytes=5-", 10, 5, 10, false},
+		{"bytes=-3", 10, 7, 10, false},
+		{"bytes=-30", 10, 0, 10, false},
+		{"bytes=8-100", 10, 8, 10, false},
+		{"bytes=10-", 10, 0, 0, true},
+		{"bytes=5-4", 10, 0, 0, true},
+		{"bytes=-0", 10, 0, 0, true},
+		{"bytes=0-1,3-4", 10, 0, 0, true},
+		{"lines=0-1", 10, 0, 0, true},
+		{"bytes=0-", 0, 0, 0, true},
+	} {
+		t.Run(tc.header, func(t *testing.T) {
+			start, end, err := parseByteRange(tc.header, tc.size)
+			if tc.expectedErr {
+				if err == nil {
+					t.Fatalf("expected error, got range %d-%d", start, end)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if start != tc.expectedStart || end != tc.expectedEnd {
+				t.Fatalf("got range %d-%d, expected %d-%d", start, end, tc.expectedStart, tc.expectedEnd)
+			}
+		})
+	}
+}
+
+func TestCmdOutput_WithBadParams(t *testing.T) {
+	id := uuid.NewString()
+	for _, tc := range []struct {
+		name        string
+		id          string
+		url         string
+		rangeHeader string
+	}{
+		{"bad id", "not-uuid", "/api/v1/cmd/not-uuid/output", ""},
+		{"bad stream", id, fmt.Sprintf("/api/v1/cmd/%s/output?stream=stdin", id), ""},
+		{"bad tail", id, fmt.Sprintf("/api/v1/cmd/%s/output?tail=0", id), ""},
+		{"bad since", id, fmt.Sprintf("/api/v1/cmd/%s/output?since=-1", id), ""},
+		{"tail and since", id, fmt.Sprintf("/api/v1/cmd/%s/output?tail=1&since=0", id), ""},
+		{"range and tail", id, fmt.Sprintf("/api/v1/cmd/%s/output?tail=1", id), "bytes=0-1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.url, nil)
+			if tc.rangeHeader != "" {
+				req.Header.Set("Range", tc.rangeHeader)
+			}
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(cmdOutputHandler)
+			req = mux.SetURLVars(req, map[string]string{
+				"id": tc.id,
+			})
+
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusBadRequest {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+			}
+			contentType := rr.Header().Get("Content-Type")
+			if contentType != "application/json" {
+				t.Fatalf("handler returned wrong content type: got %v want %v", contentType, "application/json")
+			}
+		})
+	}
+}
+
+func TestCmdOutput_WithNoCmdInDB(t *testing.T) {
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to testcontainer db: %s", err)
+	}
+	doTransactional = db.TransactionWorkerProvider(pool)
+
+	id := uuid.New()
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/cmd/%s/output", id), nil)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(cmdOutputHandler)
+	req = mux.SetURLVars(req, map[string]string{
+		"id": id.String(),
+	})
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+func TestCmdOutput_ReturnsExactBytes(t *testing.T) {
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to testcontainer db: %s", err)
+	}
+	doTransactional = db.TransactionWorkerProvider(pool)
+
+	chunks := []db.OutputChunk{
+		{Stream: db.Stdout, Data: []byte("\x89PNG\x00")},
+		{Stream: db.Stderr, Data: []byte("\xff\xfe")},
+		{Stream: db.Stdout, Data: []byte("\x00end")},
+	}
+	var id uuid.UUID
+	err = doTransactional(ctx, func(tx pgx.Tx) error {
+		newId, err := db.InsertNewCommand(ctx, tx, correctScript, db.CommandOptions{})
+		if err != nil {
+			return err
+		}
+		id = newId
+		_, err = db.AppendCommandOutputChunks(ctx, tx, id, chunks)
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		t.Fatalf("failed to insert test command into test db: %s", err)
+	}
+
+	for _, tc := range []struct {
+		stream   string
+		expected []byte
+	}{
+		{"", []byte("\x89PNG\x00\x00end")},
+		{"stderr", []byte("\xff\xfe")},
+		{"combined", []byte("\x89PNG\x00\xff\xfe\x00end")},
+	} {
+		t.Run(fmt.Sprintf("stream=%s", tc.stream), func(t *testing.T) {
+			req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/cmd/%s/output?stream=%s", id, tc.stream), nil)
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(cmdOutputHandler)
+			req = mux.SetURLVars(req, map[string]string{
+				"id": id.String(),
+			})
+
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+			}
+			contentType := rr.Header().Get("Content-Type")
+			if contentType != "application/octet-stream" {
+				t.Fatalf("handler returned wrong content type: got %v want %v", contentType, "application/octet-stream")
+			}
+			if got := rr.Body.Bytes(); !bytes.Equal(got, tc.expected) {
+				t.Fatalf("output does not match: got %q, expected %q", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestCmdOutput_ReturnsRequestedPart(t *testing.T) {
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to testcontainer db: %s", err)
+	}
+	doTransactional = db.TransactionWorkerProvider(pool)
+
+	chunks := []db.OutputChunk{
+		{Stream: db.Stdout, Data: []byte("line 1\nli")},
+		{Stream: db.Stderr, Data: []byte("error\n")},
+		{Stream: db.Stdout, Data: []byte("ne 2\nline 3\n")},
+	}
+	var id uuid.UUID
+	err = doTransactional(ctx, func(tx pgx.Tx) error {
+		newId, err := db.InsertNewCommand(ctx, tx, correctScript, db.CommandOptions{})
+		if err != nil {
+			return err
+		}
+		id = newId
+		_, err = db.AppendCommandOutputChunks(ctx, tx, id, chunks)
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		t.Fatalf("failed to insert test command into test db: %s", err)
+	}
+
+	for _, tc := range []struct {
+		name                 string
+		query                string
+		rangeHeader          string
+		expectedStatus       int
+		expected             string
+		expectedNextOffset   string
+		expectedContentRange string
+	}{
+		{"whole", "", "", http.StatusOK, "line 1\nline 2\nline 3\n", "21", ""},
+		{"range", "", "bytes=5-11", http.StatusPartialContent, "1\nline ", "12", "bytes 5-11/21"},
+		{"suffix range", "", "bytes=-7", http.StatusPartialContent, "line 3\n", "21", "bytes 14-20/21"},
+		{"combined range", "stream=combined", "bytes=7-15", http.StatusPartialContent, "lierror\nn", "16", "bytes 7-15/27"},
+		{"tail", "tail=2", "", http.StatusOK, "line 2\nline 3\n", "21", ""},
+		{"tail of combined", "stream=combined&tail=3", "", http.StatusOK, "lierror\nne 2\nline 3\n", "27", ""},
+		{"tail longer than output", "tail=10", "", http.StatusOK, "line 1\nline 2\nline 3\n", "21", ""},
+		{"since", "since=14", "", http.StatusOK, "line 3\n", "21", ""},
+		{"since end", "since=21", "", http.StatusOK, "", "21", ""},
+		{"since after end", "since=22", "", http.StatusRequestedRangeNotSatisfiable, "", "", "bytes */21"},
+		{"range after end", "", "bytes=21-", http.StatusRequestedRangeNotSatisfiable, "", "", "bytes */21"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/cmd/%s/output?%s", id, tc.query), nil)
+			if tc.rangeHeader != "" {
+				req.Header.Set("Range", tc.rangeHeader)
+			}
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(cmdOutputHandler)
+			req = mux.SetURLVars(req, map[string]string{
+				"id": id.String(),
+			})
+
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tc.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, tc.expectedStatus)
+			}
+			if got := rr.Header().Get("Content-Range"); got != tc.expectedContentRange {
+				t.Fatalf("wrong Content-Range: got %q, expected %q", got, tc.expectedContentRange)
+			}
+			if tc.expectedStatus == http.StatusRequestedRangeNotSatisfiable {
+				return
+			}
+			if got := rr.Body.String(); got != tc.expected {
+				t.Fatalf("output does not match: got %q, expected %q", got, tc.expected)
+			}
+			if got := rr.Header().Get("X-Next-Offset"); got != tc.expectedNextOffset {
+				t.Fatalf("wrong X-Next-Offset: got %q, expected %q", got, tc.expectedNextOffset)
+			}
+		})
+	}
+}
+
+func TestCmdOutput_SinceAcrossTailReplacement(t *testing.T) {
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to testcontainer db: %s", err)
+	}
+	doTransactional = db.TransactionWorkerProvider(pool)
+
+	var id uuid.UUID
+	err = doTransactional(ctx, func(tx pgx.Tx) error {
+		newId, err := db.InsertNewCommand(ctx, tx, correctScript, db.CommandOptions{})
+		if err != nil {
+			return err
+		}
+		id = newId
+		_, err = db.AppendCommandOutput(ctx, tx, id, db.Stdout, []byte("head "))
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		t.Fatalf("failed to insert test command into test db: %s", err)
+	}
+
+	// getOutput returns output after since and X-Next-Offset
+	getOutput := func(since int64) (string, int64) {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/cmd/%s/output?since=%d", id, since), nil)
+		rr := httptest.NewRecorder()
+		req = mux.SetURLVars(req, map[string]string{
+			"id": id.String(),
+		})
+		http.HandlerFunc(cmdOutputHandler).ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		next, err := strconv.ParseInt(rr.Header().Get("X-Next-Offset"), 10, 64)
+		if err != nil {
+			t.Fatalf("invalid X-Next-Offset: %s", err)
+		}
+		return rr.Body.String(), next
+	}
+
+	var since int64
+	for i, tc := range []struct {
+		tail     string
+		counted  int64
+		expected string
+	}{
+		{"tail 1", 0, "head tail 1"},
+		{"tail 2", 6, "tail 2"},
+		{"tail 3", 6, "tail 3"},
+	} {
+		err = doTransactional(ctx, func(tx pgx.Tx) error {
+			chunks := []db.OutputChunk{{Stream: db.Stdout, Data: []byte(tc.tail), Tail: true}}
+			_, err := db.ReplaceCommandOutputTail(ctx, tx, id, tc.counted, chunks)
+			if err != nil {
+				return err
+			}
+			return tx.Commit(ctx)
+		})
+		if err != nil {
+			t.Fatalf("failed to replace tail: %s", err)
+		}
+
+		output, next := getOutput(since)
+		if output != tc.expected {
+			t.Fatalf("step %d: got output %q after %d, expected %q", i, output, since, tc.expected)
+		}
+		if next <= since {
+			t.Fatalf("step %d: next offset %d does not grow after %d", i, next, since)
+		}
+		since = next
+	}
+
+	output, _ := getOutput(0)
+	if output != "head tail 3" {
+		t.Fatalf("got whole output %q, expected %q", output, "head tail 3")
+	}
+}
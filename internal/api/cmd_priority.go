@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+	"net/http"
+	"pg-test-task-2024/internal/db"
+)
+
+const (
+	minPriority = -100
+	maxPriority = 100
+)
+
+// validatePriority returns error suitable for client if priority is out of range
+func validatePriority(priority int) error {
+	if priority < minPriority || priority > maxPriority {
+		return fmt.Errorf("priority should be from %d to %d, got %d", minPriority, maxPriority, priority)
+	}
+	return nil
+}
+
+type priorityRequestDto struct {
+	Priority *int `json:"priority"`
+}
+
+type priorityResponseDto struct {
+	Id            uuid.UUID `json:"id"`
+	Priority      int       `json:"priority"`
+	QueuePosition int       `json:"queue-position"`
+}
+
+// errAlreadyStarted is returned from transaction if the command exists, but it is not queued
+var errAlreadyStarted = errors.New("command has already started")
+
+// cmdPriorityHandler changes priority of the queued command
+func cmdPriorityHandler(w http.ResponseWriter, r *http.Request) {
+	logger := getLogger(r)
+	encoder := json.NewEncoder(w)
+
+	s := mux.Vars(r)["id"]
+	id, err := uuid.Parse(s)
+	if err != nil {
+		logger.Printf("%s is invalid UUID: %s", s, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = encoder.Encode(errResponse{
+			ShortDesc: "Bad Request",
+			LongDesc:  fmt.Sprintf("Invalid url"),
+		})
+		return
+	}
+
+	var req priorityRequestDto
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&req)
+	if err == nil && req.Priority == nil {
+		err = errors.New("priority is required")
+	}
+	if err == nil {
+		err = validatePriority(*req.Priority)
+	}
+	if err != nil {
+		logger.Printf("bad request: %s", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = encoder.Encode(errResponse{
+			ShortDesc: "Bad Request",
+			LongDesc:  fmt.Sprintf("Invalid body: %s", err),
+		})
+		return
+	}
+
+	ctx := r.Context()
+	rsp := priorityResponseDto{Id: id, Priority: *req.Priority}
+	err = doTransactional(ctx, func(tx pgx.Tx) error {
+		err := db.SetQueuedCommandPriority(ctx, tx, id, *req.Priority)
+		if errors.Is(err, db.ErrEntityNotFound) {
+			_, err = db.GetSingleCommandWithoutOutput(ctx, tx, id)
+			if err == nil {
+				return errAlreadyStarted
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		rsp.QueuePosition, err = db.GetQueuePosition(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		logger.Printf("failed to change priority: %s", err)
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case errors.Is(err, db.ErrEntityNotFound):
+			w.WriteHeader(http.StatusNotFound)
+			_ = encoder.Encode(errResponse{
+				ShortDesc: "Not Found",
+				LongDesc:  "Entity with such id not found",
+			})
+		case errors.Is(err, errAlreadyStarted):
+			w.WriteHeader(http.StatusConflict)
+			_ = encoder.Encode(errResponse{
+				ShortDesc: "Conflict",
+				LongDesc:  "Priority may be changed only for queued command",
+			})
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = encoder.Encode(errResponse{
+				ShortDesc: "Internal Server Error",
+			})
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = encoder.Encode(rsp)
+	logger.Printf("priority changed to %d", rsp.Priority)
+}
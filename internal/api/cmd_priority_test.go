@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"net/http"
+	"net/http/httptest"
+	"pg-test-task-2024/internal/config"
+	"pg-test-task-2024/internal/db"
+	"pg-test-task-2024/internal/db/dbtest"
+	"strings"
+	"testing"
+)
+
+// patchPriority calls cmdPriorityHandler with body for the command
+func patchPriority(id string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("PATCH", fmt.Sprintf("/api/v1/cmd/%s/priority", id), strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{
+		"id": id,
+	})
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(cmdPriorityHandler)
+
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCmdPriority_WithBadUrl(t *testing.T) {
+	rr := patchPriority("not-uuid", `{"priority": 1}`)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+	contentType := rr.Header().Get("Content-Type")
+	if contentType != "application/json" {
+		t.Fatalf("handler returned wrong content type: got %v want %v", contentType, "application/json")
+	}
+}
+
+func TestCmdPriority_WithBadBodies(t *testing.T) {
+	for _, body := range []string{
+		"",
+		"{}",
+		`{"priority": "high"}`,
+		`{"priority": 1.5}`,
+		`{"priority": 101}`,
+		`{"priority": 1, "other": 2}`,
+	} {
+		t.Run(body, func(t *testing.T) {
+			rr := patchPriority(uuid.New().String(), body)
+
+			if status := rr.Code; status != http.StatusBadRequest {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestCmdPriority_WithCmdInDB(t *testing.T) {
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to testcontainer db: %s", err)
+	}
+	doTransactional = db.TransactionWorkerProvider(pool)
+
+	ids := make([]uuid.UUID, 0, 3)
+	for i := 0; i < 3; i++ {
+		err = doTransactional(ctx, func(tx pgx.Tx) error {
+			id, err := db.InsertNewCommand(ctx, tx, correctScript, db.CommandOptions{})
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+			return tx.Commit(ctx)
+		})
+		if err != nil {
+			t.Fatalf("failed to insert test command into test db: %s", err)
+		}
+	}
+
+	rr := patchPriority(ids[2].String(), `{"priority": 10}`)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var gotDto priorityResponseDto
+	err = json.NewDecoder(rr.Body).Decode(&gotDto)
+	if err != nil {
+		t.Fatalf("failed to decode dto")
+	}
+	if gotDto.Priority != 10 || gotDto.QueuePosition != 1 {
+		t.Fatalf("got priority %d and queue position %d, expected %d and %d",
+			gotDto.Priority, gotDto.QueuePosition, 10, 1)
+	}
+
+	// command with the highest priority is taken first, then the oldest one
+	for _, expectedId := range []uuid.UUID{ids[2], ids[0]} {
+		var gotId uuid.UUID
+		err = doTransactional(ctx, func(tx pgx.Tx) error {
+			gotId, err = db.DequeueCommand(ctx, tx)
+			if err != nil {
+				return err
+			}
+			return tx.Commit(ctx)
+		})
+		if err != nil {
+			t.Fatalf("failed to dequeue command: %s", err)
+		}
+		if gotId != expectedId {
+			t.Fatalf("dequeued %v, expected %v", gotId, expectedId)
+		}
+	}
+
+	rr = patchPriority(ids[0].String(), `{"priority": 20}`)
+	if status := rr.Code; status != http.StatusConflict {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusConflict)
+	}
+
+	rr = patchPriority(uuid.New().String(), `{"priority": 20}`)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
@@ -3,14 +3,17 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v4"
 	"io"
+	"mime"
 	"net/http"
 	"os"
 	"pg-test-task-2024/internal/config"
 	"pg-test-task-2024/internal/db"
+	"pg-test-task-2024/internal/executor"
 	"regexp"
 	"strings"
 	"time"
@@ -20,16 +23,238 @@ type cmdReceivedResponse struct {
 	Id string `json:"id"`
 }
 
-var shellPattern = regexp.MustCompile(`(^#!/bin/bash|^#!/bin/sh)`)
+// resolveTimeout returns timeout of the command considering server default and max timeouts.
+// Empty s means that timeout was not requested. Returned error is suitable for client.
+func resolveTimeout(s string) (time.Duration, error) {
+	maxTimeout := config.GetMaxTimeout()
+	if s == "" {
+		timeout := config.GetDefaultTimeout()
+		if maxTimeout > 0 && (timeout == 0 || timeout > maxTimeout) {
+			return maxTimeout, nil
+		}
+		return timeout, nil
+	}
+
+	timeout, err := time.ParseDuration(s)
+	if err != nil || timeout < time.Millisecond {
+		return 0, fmt.Errorf("timeout should be duration not less than 1ms (e.g. 90s, 5m), got %s", s)
+	}
+	if maxTimeout > 0 && timeout > maxTimeout {
+		return 0, fmt.Errorf("timeout should not exceed %s", maxTimeout)
+	}
+	return timeout, nil
+}
+
+// validateOutputLimit checks that the limit of the saved output does not exceed the server limit.
+// Returned error is suitable for client.
+func validateOutputLimit(limit int) error {
+	maxSize := config.GetMaxOutputSize()
+	if limit < 1 || limit > maxSize {
+		return fmt.Errorf("output limit should be from 1 to %d bytes, got %d", maxSize, limit)
+	}
+	return nil
+}
+
+// parseOutputPolicy returns policy of the saved output, empty s means default policy.
+// Returned error is suitable for client.
+func parseOutputPolicy(s string) (db.OutputPolicy, error) {
+	if s == "" {
+		return db.KeepHead, nil
+	}
+	policy := db.OutputPolicy(s)
+	if !policy.Valid() {
+		return "", fmt.Errorf("output policy should be one of %s, %s, %s, %s, got %s",
+			db.KeepHead, db.KeepTail, db.KeepHeadTail, db.KillOnLimit, s)
+	}
+	return policy, nil
+}
+
+// resourceLimitsDto contains resource limits of the script, omitted or 0 field means the server limit
+type resourceLimitsDto struct {
+	// Memory is max memory of the script and its descendants in bytes
+	Memory int64 `json:"memory,omitempty"`
+	// CPU is max number of CPU cores, may be fractional
+	CPU float64 `json:"cpu,omitempty"`
+	// Pids is max number of processes
+	Pids int64 `json:"pids,omitempty"`
+	// NoFile is max number of open files of each process
+	NoFile int64 `json:"nofile,omitempty"`
+	// FileSize is max size of a file written by the script in bytes
+	FileSize int64 `json:"fsize,omitempty"`
+}
+
+// validateLimit checks that the requested limit does not exceed the server limit, 0 means no limit
+func validateLimit[T int64 | float64](name string, limit, serverLimit T) error {
+	if limit < 0 {
+		return fmt.Errorf("%s limit should not be negative, got %v", name, limit)
+	}
+	if serverLimit > 0 && limit > serverLimit {
+		return fmt.Errorf("%s limit should not exceed %v, got %v", name, serverLimit, limit)
+	}
+	return nil
+}
+
+// toResourceLimits validates requested limits. Returned error is suitable for client.
+func toResourceLimits(dto resourceLimitsDto) (db.ResourceLimits, error) {
+	for _, err := range []error{
+		validateLimit("memory", dto.Memory, config.GetMemoryLimit()),
+		validateLimit("cpu", dto.CPU, config.GetCPULimit()),
+		validateLimit("pids", dto.Pids, config.GetPidsLimit()),
+		validateLimit("nofile", dto.NoFile, config.GetNoFileLimit()),
+		validateLimit("fsize", dto.FileSize, config.GetFileSizeLimit()),
+	} {
+		if err != nil {
+			return db.ResourceLimits{}, err
+		}
+	}
+	return db.ResourceLimits{
+		Memory:   dto.Memory,
+		CPU:      dto.CPU,
+		Pids:     dto.Pids,
+		NoFile:   dto.NoFile,
+		FileSize: dto.FileSize,
+	}, nil
+}
+
+// cmdRequestDto is a body of application/json request. Interactive, Timeout, Priority,
+// OutputLimit, OutputPolicy and Sandbox may also be passed as query parameters,
+// values from the body take precedence.
+type cmdRequestDto struct {
+	Script      string            `json:"script"`
+	Args        []string          `json:"args"`
+	Env         map[string]string `json:"env"`
+	Stdin       *string           `json:"stdin"`
+	Interactive *bool             `json:"interactive"`
+	Timeout     *string           `json:"timeout"`
+	Priority    *int              `json:"priority"`
+	// OutputLimit is max size of the saved output in bytes
+	OutputLimit  *int    `json:"output-limit"`
+	OutputPolicy *string `json:"output-policy"`
+	// Limits may be only lowered compared to the server limits
+	Limits *resourceLimitsDto `json:"limits"`
+	// Sandbox is the server default if not set
+	Sandbox *bool `json:"sandbox"`
+}
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// toCommandOptions validates request and builds options of the command.
+// Returned error is suitable for client.
+func toCommandOptions(req cmdRequestDto, r *http.Request) (db.CommandOptions, error) {
+	opts := db.CommandOptions{
+		Args:      req.Args,
+		Env:       req.Env,
+		Stdin:     req.Stdin,
+		RequestId: getRequestId(r),
+	}
+
+	if strings.ContainsRune(req.Script, 0) {
+		return db.CommandOptions{}, fmt.Errorf("script should not contain NUL character")
+	}
+	if req.Stdin != nil && strings.ContainsRune(*req.Stdin, 0) {
+		return db.CommandOptions{}, fmt.Errorf("stdin should not contain NUL character")
+	}
+	for _, arg := range req.Args {
+		if strings.ContainsRune(arg, 0) {
+			return db.CommandOptions{}, fmt.Errorf("arguments should not contain NUL character")
+		}
+	}
+	for name, value := range req.Env {
+		if !envNamePattern.MatchString(name) {
+			return db.CommandOptions{}, fmt.Errorf("invalid name of environment variable: %q", name)
+		}
+		if strings.ContainsRune(value, 0) {
+			return db.CommandOptions{}, fmt.Errorf("environment variable %s should not contain NUL character", name)
+		}
+	}
+
+	if req.Interactive != nil {
+		opts.Interactive = *req.Interactive
+	} else {
+		interactive, err := parseBoolQueryParam(r, "interactive")
+		if err != nil {
+			return db.CommandOptions{}, err
+		}
+		opts.Interactive = interactive
+	}
+
+	timeout := r.URL.Query().Get("timeout")
+	if req.Timeout != nil {
+		timeout = *req.Timeout
+	}
+	var err error
+	opts.Timeout, err = resolveTimeout(timeout)
+	if err != nil {
+		return db.CommandOptions{}, err
+	}
+
+	priority := req.Priority
+	if priority == nil {
+		priority, err = parseIntParam(r.URL.Query(), "priority")
+		if err != nil {
+			return db.CommandOptions{}, err
+		}
+	}
+	if priority != nil {
+		err = validatePriority(*priority)
+		if err != nil {
+			return db.CommandOptions{}, err
+		}
+		opts.Priority = *priority
+	}
+
+	outputLimit := req.OutputLimit
+	if outputLimit == nil {
+		outputLimit, err = parseIntParam(r.URL.Query(), "output-limit")
+		if err != nil {
+			return db.CommandOptions{}, err
+		}
+	}
+	if outputLimit != nil {
+		err = validateOutputLimit(*outputLimit)
+		if err != nil {
+			return db.CommandOptions{}, err
+		}
+		opts.OutputLimit = int64(*outputLimit)
+	}
+
+	outputPolicy := r.URL.Query().Get("output-policy")
+	if req.OutputPolicy != nil {
+		outputPolicy = *req.OutputPolicy
+	}
+	opts.OutputPolicy, err = parseOutputPolicy(outputPolicy)
+	if err != nil {
+		return db.CommandOptions{}, err
+	}
 
-// isShellScript checks if file starts with any of sequences
-//   - #!/bin/bash
-//   - #!/bin/sh
-//
-// Check is performed with regular expressions.
-// I'm sure there is more suitable way to do it.
-func isShellScript(content string) bool {
-	return shellPattern.MatchString(content)
+	if req.Limits != nil {
+		opts.Limits, err = toResourceLimits(*req.Limits)
+		if err != nil {
+			return db.CommandOptions{}, err
+		}
+	}
+
+	switch {
+	case req.Sandbox != nil:
+		opts.Sandbox = *req.Sandbox
+	case r.URL.Query().Has("sandbox"):
+		opts.Sandbox, err = parseBoolQueryParam(r, "sandbox")
+		if err != nil {
+			return db.CommandOptions{}, err
+		}
+	default:
+		opts.Sandbox = config.GetSandboxDefault()
+	}
+	return opts, nil
+}
+
+// isSupportedTextCharset returns true if text in such charset may be stored as is
+func isSupportedTextCharset(charset string) bool {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii":
+		return true
+	}
+	return false
 }
 
 func cmdReceiveHandler(w http.ResponseWriter, r *http.Request) {
@@ -37,26 +262,115 @@ func cmdReceiveHandler(w http.ResponseWriter, r *http.Request) {
 	encoder := json.NewEncoder(w)
 
 	contentType := r.Header.Get("Content-Type")
-	if contentType != "text/plain" {
-		logger.Printf("Invalid content type: %s, expected text/plain", contentType)
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil ||
+		(mediaType != "text/plain" && mediaType != "application/json" && mediaType != "multipart/form-data") ||
+		!isSupportedTextCharset(params["charset"]) {
+		logger.Printf("Invalid content type: %s, expected text/plain, application/json or multipart/form-data",
+			contentType)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusUnsupportedMediaType)
 		_ = encoder.Encode(errResponse{
 			ShortDesc: "Unsupported Media Type",
-			LongDesc:  fmt.Sprintf("Bad Content-Type, expected text/plain, got %s", contentType),
+			LongDesc: fmt.Sprintf(
+				"Bad Content-Type, expected text/plain or application/json in UTF-8 or multipart/form-data, got %s",
+				contentType),
+		})
+		return
+	}
+
+	// uploaded files are limited separately, the rest of the body is limited with max request size
+	maxBodySize := int64(config.GetMaxRequestSize())
+	if mediaType == "multipart/form-data" {
+		maxBodySize += int64(config.GetMaxInputFilesSize())
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+	var req cmdRequestDto
+	// inputFiles is a directory with files uploaded with the script, it is moved
+	// to the command dir after the command is saved
+	inputFiles := ""
+	switch mediaType {
+	case "application/json":
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&req)
+	case "multipart/form-data":
+		inputFiles, err = os.MkdirTemp(config.GetCmdDir(), "upload-")
+		if err != nil {
+			logger.Printf("failed to create directory for input files: %s", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = encoder.Encode(errResponse{
+				ShortDesc: "Internal Server Error",
+			})
+			return
+		}
+		defer os.RemoveAll(inputFiles)
+		req, err = readMultipartRequest(r, inputFiles)
+	default:
+		var bytes []byte
+		bytes, err = io.ReadAll(r.Body)
+		req.Script = string(bytes)
+	}
+	var maxBytesErr *http.MaxBytesError
+	if err != nil {
+		logger.Printf("failed to read body: %s", err)
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case errors.Is(err, errInputFileNotSaved):
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = encoder.Encode(errResponse{
+				ShortDesc: "Internal Server Error",
+			})
+		case errors.Is(err, errInputFilesTooLarge), errors.Is(err, errRequestTooLarge):
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			_ = encoder.Encode(errResponse{
+				ShortDesc: "Request Entity Too Large",
+				LongDesc:  err.Error(),
+			})
+		case errors.As(err, &maxBytesErr):
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			_ = encoder.Encode(errResponse{
+				ShortDesc: "Request Entity Too Large",
+				LongDesc:  fmt.Sprintf("Max size of the body is %d bytes", maxBytesErr.Limit),
+			})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			_ = encoder.Encode(errResponse{
+				ShortDesc: "Bad Request",
+				LongDesc:  fmt.Sprintf("Invalid body: %s", err),
+			})
+		}
+		return
+	}
+
+	opts, err := toCommandOptions(req, r)
+	if err != nil {
+		logger.Printf("bad request: %s", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = encoder.Encode(errResponse{
+			ShortDesc: "Bad Request",
+			LongDesc:  err.Error(),
 		})
 		return
 	}
 
-	bytes, _ := io.ReadAll(r.Body)
-	src := string(bytes)
-	if !isShellScript(src) {
-		logger.Printf("Not a shell script")
+	src := req.Script
+	interpreters := config.GetInterpreters()
+	_, err = executor.ParseShebang(src, interpreters)
+	if err != nil {
+		logger.Printf("bad shebang: %s", err)
+		longDesc := err.Error()
+		if errors.Is(err, executor.ErrUnknownInterpreter) {
+			longDesc = fmt.Sprintf("%s, allowed interpreters: %s", err, strings.Join(interpreters, ", "))
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
 		_ = encoder.Encode(errResponse{
 			ShortDesc: "Bad Request",
-			LongDesc:  "Not a shell script",
+			LongDesc:  longDesc,
 		})
 		return
 	}
@@ -65,19 +379,31 @@ func cmdReceiveHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
 	defer cancel()
 	var commandId uuid.UUID
-	err := doTransactional(ctx, func(tx pgx.Tx) error {
-		id, err := db.InsertNewCommand(ctx, tx, src)
+	err = doTransactional(ctx, func(tx pgx.Tx) error {
+		id, err := db.InsertNewCommand(ctx, tx, src, opts)
 		if err != nil {
 			return fmt.Errorf("failed to insert new command in db: %s", err)
 		}
 
-		// create file with -rwx------ permissions
-		f, err := os.OpenFile(config.GetCmdDir()+id.String(), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		// create file with -rw------- permissions
+		f, err := os.OpenFile(config.GetCmdDir()+id.String(), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 		if err != nil {
 			return fmt.Errorf("failed to create file: %s", err)
 		}
 		defer f.Close()
 
+		// script executed as other user may read the file, but may not change it
+		if users := config.GetScriptUsers(); users != nil {
+			err = f.Chown(-1, int(users.GID))
+			if err == nil {
+				err = f.Chmod(0640)
+			}
+			if err != nil {
+				_ = os.Remove(f.Name())
+				return fmt.Errorf("failed to change file permissions: %s", err)
+			}
+		}
+
 		written, err := io.WriteString(f, src)
 		if err != nil {
 			_ = os.Remove(f.Name())
@@ -91,9 +417,17 @@ func cmdReceiveHandler(w http.ResponseWriter, r *http.Request) {
 				written, r.ContentLength)
 		}
 
+		if inputFiles != "" {
+			err = os.Rename(inputFiles, executor.InputFilesDir(id))
+			if err != nil {
+				_ = os.Remove(f.Name())
+				return fmt.Errorf("failed to move input files: %s", err)
+			}
+		}
+
 		err = tx.Commit(ctx)
 		if err != nil {
-			_ = os.Remove(f.Name())
+			executor.RemoveCmdFiles(id)
 			return fmt.Errorf("failed to commit changes: %s", err)
 		}
 
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"pg-test-task-2024/internal/config"
+	"pg-test-task-2024/internal/executor"
+	"strings"
+)
+
+// names of the parts of multipart/form-data request
+const (
+	// scriptPart contains the script, it is required
+	scriptPart = "script"
+	// optionsPart is optional json with the same fields as application/json body except script
+	optionsPart = "options"
+	// filePart contains file which is placed in the working directory of the script,
+	// filename parameter is a name of the file
+	filePart = "file"
+)
+
+// maxInputFileNameLength is max length of the name of the uploaded file in bytes
+const maxInputFileNameLength = 255
+
+var (
+	// errRequestTooLarge is returned if the script and options exceed max request size
+	errRequestTooLarge = errors.New("request is too large")
+	// errInputFilesTooLarge is returned if uploaded files exceed the server limits
+	errInputFilesTooLarge = errors.New("input files are too large")
+	// errInputFileNotSaved is returned if uploaded file can't be saved because of the server error
+	errInputFileNotSaved = errors.New("failed to save input file")
+)
+
+// validateInputFileName checks that the file is placed directly in the working directory.
+// Returned error is suitable for client.
+func validateInputFileName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("file part should have filename")
+	case len(name) > maxInputFileNameLength:
+		return fmt.Errorf("file name should not be longer than %d bytes", maxInputFileNameLength)
+	case name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00"):
+		return fmt.Errorf("file name should not contain path separators, got %q", name)
+	case name == executor.ArtifactsDirName:
+		return fmt.Errorf("file name %s is reserved for artifacts directory", name)
+	}
+	return nil
+}
+
+// partFileName returns filename parameter of the part as is.
+// multipart.Part.FileName is not used, as it silently drops directories.
+func partFileName(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+	return params["filename"]
+}
+
+// readMultipartRequest reads the script and options from multipart/form-data request,
+// uploaded files are saved to dir. Returned error is suitable for client,
+// errRequestTooLarge and errInputFilesTooLarge are returned if the request exceeds the server limits.
+// Errors wrapping errInputFileNotSaved are not caused by the client.
+func readMultipartRequest(r *http.Request, dir string) (cmdRequestDto, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return cmdRequestDto{}, err
+	}
+
+	var req cmdRequestDto
+	var script *string
+	hasOptions := false
+	files := make(map[string]struct{})
+	maxFiles, maxSize := config.GetMaxInputFiles(), int64(config.GetMaxInputFileSize())
+	remaining := int64(config.GetMaxInputFilesSize())
+	// script and options are limited together, the same way as json body
+	maxRequestSize := int64(config.GetMaxRequestSize())
+	remainingRequest := maxRequestSize
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return cmdRequestDto{}, err
+		}
+
+		switch part.FormName() {
+		case scriptPart:
+			if script != nil {
+				return cmdRequestDto{}, errors.New("request should contain one script part")
+			}
+			bytes, err := io.ReadAll(io.LimitReader(part, remainingRequest+1))
+			if err != nil {
+				return cmdRequestDto{}, err
+			}
+			if int64(len(bytes)) > remainingRequest {
+				return cmdRequestDto{}, fmt.Errorf("%w: max size of the script and options is %d bytes",
+					errRequestTooLarge, maxRequestSize)
+			}
+			remainingRequest -= int64(len(bytes))
+			s := string(bytes)
+			script = &s
+		case optionsPart:
+			if hasOptions {
+				return cmdRequestDto{}, errors.New("request should contain at most one options part")
+			}
+			hasOptions = true
+			limited := &io.LimitedReader{R: part, N: remainingRequest + 1}
+			decoder := json.NewDecoder(limited)
+			decoder.DisallowUnknownFields()
+			err = decoder.Decode(&req)
+			if limited.N == 0 {
+				return cmdRequestDto{}, fmt.Errorf("%w: max size of the script and options is %d bytes",
+					errRequestTooLarge, maxRequestSize)
+			}
+			remainingRequest = limited.N - 1
+			if err != nil {
+				return cmdRequestDto{}, fmt.Errorf("invalid options: %w", err)
+			}
+			if req.Script != "" {
+				return cmdRequestDto{}, errors.New("script should be passed in script part, not in options")
+			}
+		case filePart:
+			name := partFileName(part)
+			err = validateInputFileName(name)
+			if err != nil {
+				return cmdRequestDto{}, err
+			}
+			if _, ok := files[name]; ok {
+				return cmdRequestDto{}, fmt.Errorf("file %s is uploaded more than once", name)
+			}
+			if len(files) == maxFiles {
+				return cmdRequestDto{}, fmt.Errorf("%w: at most %d files may be uploaded", errInputFilesTooLarge, maxFiles)
+			}
+			files[name] = struct{}{}
+			size, err := saveInputFile(part, filepath.Join(dir, name), min(maxSize, remaining))
+			if err != nil {
+				return cmdRequestDto{}, err
+			}
+			remaining -= size
+		default:
+			return cmdRequestDto{}, fmt.Errorf("unknown part %q, expected %s, %s or %s",
+				part.FormName(), scriptPart, optionsPart, filePart)
+		}
+	}
+	if script == nil {
+		return cmdRequestDto{}, errors.New("request should contain script part")
+	}
+	req.Script = *script
+	return req, nil
+}
+
+// inputFileWriter wraps errors of writing the uploaded file with errInputFileNotSaved,
+// so they are not confused with errors of reading the request
+type inputFileWriter struct {
+	f *os.File
+}
+
+func (w inputFileWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	if err != nil {
+		err = fmt.Errorf("%w: %w", errInputFileNotSaved, err)
+	}
+	return n, err
+}
+
+// saveInputFile writes content of the part to path, if it does not exceed limit
+func saveInputFile(part io.Reader, path string, limit int64) (int64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", errInputFileNotSaved, err)
+	}
+	size, err := io.Copy(inputFileWriter{f: f}, io.LimitReader(part, limit+1))
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("%w: %w", errInputFileNotSaved, closeErr)
+	}
+	if err == nil && size > limit {
+		err = fmt.Errorf("%w: max file size is %d bytes, max total size is %d bytes", errInputFilesTooLarge,
+			config.GetMaxInputFileSize(), config.GetMaxInputFilesSize())
+	}
+	return size, err
+}
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"pg-test-task-2024/internal/config"
+	"pg-test-task-2024/internal/db"
+	"pg-test-task-2024/internal/db/dbtest"
+	"pg-test-task-2024/internal/executor"
+	"strings"
+	"testing"
+)
+
+// multipartFile is a file part of the request, name is passed as filename as is
+type multipartFile struct {
+	name string
+	data string
+}
+
+// newMultipartRequest builds POST request with script, options (if not empty) and files
+func newMultipartRequest(t *testing.T, script, options string, files []multipartFile) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	err := writer.WriteField(scriptPart, script)
+	if err == nil && options != "" {
+		err = writer.WriteField(optionsPart, options)
+	}
+	for _, file := range files {
+		if err != nil {
+			break
+		}
+		header := make(textproto.MIMEHeader)
+		// CreateFormFile escapes backslashes, so the header is written directly to pass names as is
+		header.Set("Content-Disposition", `form-data; name="file"; filename="`+file.name+`"`)
+		var part io.Writer
+		part, err = writer.CreatePart(header)
+		if err == nil {
+			_, err = part.Write([]byte(file.data))
+		}
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		t.Fatalf("failed to build multipart body: %v", err)
+	}
+	req := httptest.NewRequest("POST", "/api/v1/cmd", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestValidateInputFileName(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		valid bool
+	}{
+		{"config.yaml", true},
+		{".env", true},
+		{"..data", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../etc/passwd", false},
+		{"/etc/passwd", false},
+		{"dir/file", false},
+		{"..\\file", false},
+		{"file\x00", false},
+		{"artifacts", false},
+		{strings.Repeat("a", 256), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateInputFileName(tc.name)
+			if tc.valid && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatalf("expected error")
+			}
+		})
+	}
+}
+
+func TestReadMultipartRequest(t *testing.T) {
+	dir := t.TempDir()
+	req := newMultipartRequest(t, correctScript, `{"args": ["data.csv"]}`, []multipartFile{
+		{"data.csv", "a,b\n1,2\n"},
+		{"config.yaml", "key: value\n"},
+	})
+
+	got, err := readMultipartRequest(req, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Script != correctScript || len(got.Args) != 1 || got.Args[0] != "data.csv" {
+		t.Fatalf("got request %+v", got)
+	}
+	for name, expected := range map[string]string{"data.csv": "a,b\n1,2\n", "config.yaml": "key: value\n"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("file %s is not saved: %v", name, err)
+		}
+		if string(data) != expected {
+			t.Fatalf("got content of %s %q, expected %q", name, data, expected)
+		}
+	}
+}
+
+func TestReadMultipartRequest_WithBadRequest(t *testing.T) {
+	t.Setenv("EXECUTOR_MAX_INPUT_FILES", "2")
+	t.Setenv("EXECUTOR_MAX_INPUT_FILE_SIZE", "4")
+	t.Setenv("EXECUTOR_MAX_INPUT_FILES_SIZE", "6")
+	for _, tc := range []struct {
+		name     string
+		options  string
+		files    []multipartFile
+		tooLarge bool
+	}{
+		{"path traversal", "", []multipartFile{{"../escape", "a"}}, false},
+		{"absolute path", "", []multipartFile{{"/tmp/escape", "a"}}, false},
+		{"duplicate file", "", []multipartFile{{"a", "a"}, {"a", "b"}}, false},
+		{"script in options", `{"script": "#!/bin/sh\n"}`, nil, false},
+		{"unknown option", `{"unknown": true}`, nil, false},
+		{"too large file", "", []multipartFile{{"a", "12345"}}, true},
+		{"too large total size", "", []multipartFile{{"a", "1234"}, {"b", "123"}}, true},
+		{"too many files", "", []multipartFile{{"a", ""}, {"b", ""}, {"c", ""}}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			req := newMultipartRequest(t, correctScript, tc.options, tc.files)
+
+			_, err := readMultipartRequest(req, dir)
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+			if tooLarge := errors.Is(err, errInputFilesTooLarge); tooLarge != tc.tooLarge {
+				t.Fatalf("got error %v, expected too large: %v", err, tc.tooLarge)
+			}
+			if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escape")); !os.IsNotExist(err) {
+				t.Fatalf("file is written outside of the directory")
+			}
+		})
+	}
+}
+
+func TestReadMultipartRequest_WithTooLargeScriptOrOptions(t *testing.T) {
+	// script and options are limited together
+	t.Setenv("EXECUTOR_MAX_REQUEST_SIZE", fmt.Sprint(len(correctScript)+16))
+	for _, tc := range []struct {
+		name    string
+		script  string
+		options string
+	}{
+		{"too large script", correctScript + strings.Repeat("#", 17), ""},
+		{"too large options", correctScript, `{"args": ["123456789"]}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newMultipartRequest(t, tc.script, tc.options, nil)
+
+			_, err := readMultipartRequest(req, t.TempDir())
+			if !errors.Is(err, errRequestTooLarge) {
+				t.Fatalf("got error %v, expected too large request", err)
+			}
+		})
+	}
+
+	req := newMultipartRequest(t, correctScript, `{"args": ["1"]}`, nil)
+	_, err := readMultipartRequest(req, t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSaveInputFile_WithWriteError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.csv")
+	// file opened only for reading fails on write
+	err := os.WriteFile(path, nil, 0600)
+	if err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+	defer f.Close()
+
+	_, err = io.Copy(inputFileWriter{f: f}, strings.NewReader("a,b\n"))
+	if !errors.Is(err, errInputFileNotSaved) {
+		t.Fatalf("got error %v, expected error of the server", err)
+	}
+}
+
+func TestReadMultipartRequest_WithoutScript(t *testing.T) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField(optionsPart, `{"args": ["a"]}`)
+	_ = writer.Close()
+	req := httptest.NewRequest("POST", "/api/v1/cmd", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	_, err := readMultipartRequest(req, t.TempDir())
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestCmdReceiveHandler_WithMultipartBody(t *testing.T) {
+	err := config.PrepareCmdDir(config.GetCmdDir())
+	if err != nil {
+		t.Fatalf("failed to prepare cmd dir: %v", err)
+	}
+
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to testcontainer db: %s", err)
+	}
+	doTransactional = db.TransactionWorkerProvider(pool)
+	execChan := make(chan uuid.UUID, 1)
+	submit = executor.SubmitterProvider(execChan)
+	t.Cleanup(func() {
+		doTransactional = nil
+		submit = nil
+	})
+
+	req := newMultipartRequest(t, correctScript, `{"args": ["data.csv"]}`, []multipartFile{{"data.csv", "a,b\n"}})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(cmdReceiveHandler).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var rsp cmdReceivedResponse
+	err = json.NewDecoder(rr.Body).Decode(&rsp)
+	if err != nil {
+		t.Fatalf("unexpected error decoding response: %v", err)
+	}
+	id, err := uuid.Parse(rsp.Id)
+	if err != nil {
+		t.Fatalf("unexpected error parsing id: %v", err)
+	}
+	t.Cleanup(func() {
+		executor.RemoveCmdFiles(id)
+	})
+
+	data, err := os.ReadFile(filepath.Join(executor.InputFilesDir(id), "data.csv"))
+	if err != nil || string(data) != "a,b\n" {
+		t.Fatalf("input file is not saved: %q, %v", data, err)
+	}
+	var opts db.CommandOptions
+	err = doTransactional(ctx, func(tx pgx.Tx) error {
+		opts, err = db.GetCommandOptions(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to get options of inserted command: %s", err)
+	}
+	if len(opts.Args) != 1 || opts.Args[0] != "data.csv" {
+		t.Fatalf("args do not match: got %v", opts.Args)
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -22,7 +23,7 @@ import (
 
 func TestCmdReceiveHandler_WithWrongContentType(t *testing.T) {
 	req := httptest.NewRequest("POST", "/api/v1/cmd", nil)
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", "application/xml")
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(cmdReceiveHandler)
 
@@ -37,6 +38,231 @@ func TestCmdReceiveHandler_WithWrongContentType(t *testing.T) {
 	}
 }
 
+func TestCmdReceiveHandler_WithWrongCharset(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/v1/cmd", strings.NewReader(correctScript))
+	req.Header.Set("Content-Type", "text/plain; charset=koi8-r")
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(cmdReceiveHandler)
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnsupportedMediaType {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusUnsupportedMediaType)
+	}
+}
+
+func TestCmdReceiveHandler_WithTooLargeBody(t *testing.T) {
+	t.Setenv("EXECUTOR_MAX_REQUEST_SIZE", "16")
+	for _, contentType := range []string{"text/plain", "application/json"} {
+		t.Run(contentType, func(t *testing.T) {
+			body := correctScript
+			if contentType == "application/json" {
+				body = `{"script": "#!/bin/sh\nls\n"}`
+			}
+			req := httptest.NewRequest("POST", "/api/v1/cmd", strings.NewReader(body))
+			req.Header.Set("Content-Type", contentType)
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(cmdReceiveHandler)
+
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusRequestEntityTooLarge {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusRequestEntityTooLarge)
+			}
+		})
+	}
+}
+
+var badJsonBodies = []string{
+	``,
+	`{"script": 5}`,
+	`{"script": "#!/bin/sh\nls\n", "unknown": true}`,
+	`{"script": "#!/bin/sh\nls\n", "env": {"1BAD": "x"}}`,
+	`{"script": "#!/bin/sh\nls\n", "env": {"A=B": "x"}}`,
+	`{"script": "#!/bin/sh\nls\n", "args": ["a\u0000b"]}`,
+	`{"script": "#!/bin/sh\nls\n", "stdin": "a\u0000b"}`,
+	`{"script": "#!/bin/sh\nls\u0000\n"}`,
+	`{"script": "#!/bin/sh\nls\n", "timeout": "forever"}`,
+	`{"script": "ls\n"}`,
+}
+
+func TestCmdReceiveHandler_WithBadJsonBodies(t *testing.T) {
+	for i, body := range badJsonBodies {
+		t.Run(fmt.Sprintf("bad body %v", i), func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/cmd", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(cmdReceiveHandler)
+
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusBadRequest {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+			}
+			if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Fatalf("handler returned wrong content type: got %v want %v", contentType, "application/json")
+			}
+		})
+	}
+}
+
+func TestCmdReceiveHandler_WithNulInPlainScript(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/v1/cmd", strings.NewReader("#!/bin/sh\nls\x00\n"))
+	req.Header.Set("Content-Type", "text/plain")
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(cmdReceiveHandler)
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestCmdReceiveHandler_WithBadTimeout(t *testing.T) {
+	t.Setenv("EXECUTOR_MAX_TIMEOUT", "1h")
+	for _, timeout := range []string{"soon", "-5s", "0s", "2h"} {
+		t.Run(timeout, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/cmd?timeout="+timeout, strings.NewReader(correctScript))
+			req.Header.Set("Content-Type", "text/plain")
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(cmdReceiveHandler)
+
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusBadRequest {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestCmdReceiveHandler_WithBadPriority(t *testing.T) {
+	for _, priority := range []string{"high", "1.5", "101", "-101"} {
+		t.Run(priority, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/cmd?priority="+priority, strings.NewReader(correctScript))
+			req.Header.Set("Content-Type", "text/plain")
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(cmdReceiveHandler)
+
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusBadRequest {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestCmdReceiveHandler_WithBadOutputOptions(t *testing.T) {
+	t.Setenv("EXECUTOR_MAX_OUTPUT_SIZE", "1024")
+	for _, query := range []string{
+		"output-limit=big",
+		"output-limit=0",
+		"output-limit=1025",
+		"output-policy=middle",
+	} {
+		t.Run(query, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/cmd?"+query, strings.NewReader(correctScript))
+			req.Header.Set("Content-Type", "text/plain")
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(cmdReceiveHandler)
+
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusBadRequest {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestCmdReceiveHandler_WithBadLimits(t *testing.T) {
+	t.Setenv("EXECUTOR_MEMORY_LIMIT", "1048576")
+	t.Setenv("EXECUTOR_CPU_LIMIT", "1")
+	for _, limits := range []string{
+		`{"memory": 2097152}`,
+		`{"memory": -1}`,
+		`{"cpu": 1.5}`,
+		`{"pids": -5}`,
+		`{"nofile": "many"}`,
+	} {
+		t.Run(limits, func(t *testing.T) {
+			body := fmt.Sprintf(`{"script": %q, "limits": %s}`, correctScript, limits)
+			req := httptest.NewRequest("POST", "/api/v1/cmd", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(cmdReceiveHandler)
+
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusBadRequest {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestToCommandOptions_Sandbox(t *testing.T) {
+	t.Setenv("EXECUTOR_SANDBOX", "true")
+	enabled, disabled := true, false
+	for _, tc := range []struct {
+		query    string
+		body     *bool
+		expected bool
+	}{
+		{"", nil, true},
+		{"?sandbox=false", nil, false},
+		{"?sandbox=false", &enabled, true},
+		{"", &disabled, false},
+	} {
+		r := httptest.NewRequest("POST", "/api/v1/cmd"+tc.query, nil)
+		opts, err := toCommandOptions(cmdRequestDto{Script: correctScript, Sandbox: tc.body}, r)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if opts.Sandbox != tc.expected {
+			t.Fatalf("got sandbox %v for query %q and body %v, expected %v", opts.Sandbox, tc.query, tc.body, tc.expected)
+		}
+	}
+
+	r := httptest.NewRequest("POST", "/api/v1/cmd?sandbox=maybe", nil)
+	_, err := toCommandOptions(cmdRequestDto{Script: correctScript}, r)
+	if err == nil {
+		t.Fatalf("expected error for invalid sandbox parameter")
+	}
+}
+
+func TestResolveTimeout(t *testing.T) {
+	testCases := []struct {
+		defaultTimeout string
+		maxTimeout     string
+		requested      string
+		expected       time.Duration
+	}{
+		{"", "", "", 0},
+		{"", "", "90s", 90 * time.Second},
+		{"1m", "", "", time.Minute},
+		{"", "1h", "", time.Hour},
+		{"2h", "1h", "", time.Hour},
+		{"1m", "1h", "30m", 30 * time.Minute},
+	}
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("case %v", i), func(t *testing.T) {
+			t.Setenv("EXECUTOR_DEFAULT_TIMEOUT", tc.defaultTimeout)
+			t.Setenv("EXECUTOR_MAX_TIMEOUT", tc.maxTimeout)
+
+			got, err := resolveTimeout(tc.requested)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.expected {
+				t.Fatalf("got timeout %v, expected %v", got, tc.expected)
+			}
+		})
+	}
+}
+
 var notShellScripts = []string{
 	`#/bin/bash`,
 	`!/bin/bash`,
@@ -45,7 +271,7 @@ var notShellScripts = []string{
 
 func testCmdReceiveHandler_WithNotShellScript(t *testing.T, script string) {
 	req := httptest.NewRequest("POST", "/api/v1/cmd", strings.NewReader(script))
-	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
 
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(cmdReceiveHandler)
@@ -252,8 +478,98 @@ func TestCmdReceiveHandler_WithShellScript(t *testing.T) {
 	checkCommandsEntities(t, resEntity, db.CommandEntity{
 		Id:     parsedId,
 		Source: correctScript,
-		Status: db.Running,
+		Status: db.Queued,
+	})
+
+	close(execChan)
+	gotId := <-execChan
+	if gotId != parsedId {
+		t.Fatalf("expected sending id to executor's chan: got %v expected %v", gotId, parsedId)
+	}
+}
+
+func TestCmdReceiveHandler_WithJsonBody(t *testing.T) {
+	err := config.PrepareCmdDir(config.GetCmdDir())
+	if err != nil {
+		t.Fatalf("failed to prepare cmd dir: %v", err)
+	}
+
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to testcontainer db: %s", err)
+	}
+	doTransactional = db.TransactionWorkerProvider(pool)
+	execChan := make(chan uuid.UUID, 1)
+	submit = executor.SubmitterProvider(execChan)
+	t.Cleanup(func() {
+		doTransactional = nil
+		submit = nil
+	})
+
+	sandbox := true
+	body, _ := json.Marshal(cmdRequestDto{
+		Script:  correctScript,
+		Args:    []string{"first", "second"},
+		Env:     map[string]string{"GREETING": "hello"},
+		Stdin:   &correctScript,
+		Limits:  &resourceLimitsDto{Memory: 1 << 20, NoFile: 64},
+		Sandbox: &sandbox,
+	})
+	req := httptest.NewRequest("POST", "/api/v1/cmd", strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(requestIdHeader, "req-42")
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(cmdReceiveHandler)
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	decoder := json.NewDecoder(rr.Body)
+	var rsp cmdReceivedResponse
+	err = decoder.Decode(&rsp)
+	if err != nil {
+		t.Fatalf("unexpected error decoding response: %v", err)
+	}
+	parsedId, err := uuid.Parse(rsp.Id)
+	if err != nil {
+		t.Fatalf("unexpected error parsing id: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = os.Remove(config.GetCmdDir() + rsp.Id)
+	})
+
+	var opts db.CommandOptions
+	err = doTransactional(ctx, func(tx pgx.Tx) error {
+		opts, err = db.GetCommandOptions(ctx, tx, parsedId)
+		return err
 	})
+	if err != nil {
+		t.Fatalf("failed to get options of inserted command: %s", err)
+	}
+
+	if len(opts.Args) != 2 || opts.Args[0] != "first" || opts.Args[1] != "second" {
+		t.Fatalf("args do not match: got %v", opts.Args)
+	}
+	if len(opts.Env) != 1 || opts.Env["GREETING"] != "hello" {
+		t.Fatalf("env does not match: got %v", opts.Env)
+	}
+	if opts.Stdin == nil || *opts.Stdin != correctScript {
+		t.Fatalf("stdin does not match: got %v", opts.Stdin)
+	}
+	if opts.Limits != (db.ResourceLimits{Memory: 1 << 20, NoFile: 64}) {
+		t.Fatalf("limits do not match: got %+v", opts.Limits)
+	}
+	if !opts.Sandbox {
+		t.Fatalf("sandbox is not saved")
+	}
+	if opts.RequestId != "req-42" {
+		t.Fatalf("request id does not match: got %q", opts.RequestId)
+	}
 
 	close(execChan)
 	gotId := <-execChan
@@ -275,9 +591,12 @@ func checkCommandsEntities(t *testing.T, got, expected db.CommandEntity) {
 	if got.StatusDesc != expected.StatusDesc {
 		t.Fatalf("status descriptions do not match: got %v, expected %v", got.StatusDesc, expected.StatusDesc)
 	}
-	if got.Output != expected.Output {
+	if !bytes.Equal(got.Output, expected.Output) {
 		t.Fatalf("outputs do not match:\ngot:\n%v\n----------\nexpected:\n%v\n", got.Output, expected.Output)
 	}
+	if !bytes.Equal(got.Stderr, expected.Stderr) {
+		t.Fatalf("stderrs do not match:\ngot:\n%v\n----------\nexpected:\n%v\n", got.Stderr, expected.Stderr)
+	}
 	if got.ExitCode != expected.ExitCode {
 		t.Fatalf("exit codes do not match: got %v, expected %v", got.ExitCode, expected.ExitCode)
 	}
@@ -0,0 +1,290 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+	"net/http"
+	"pg-test-task-2024/internal/db"
+	"pg-test-task-2024/internal/executor"
+)
+
+type outputEventDto struct {
+	Stream string `json:"stream"`
+	Data   string `json:"data"`
+}
+
+type endEventDto struct {
+	Status     string `json:"status"`
+	StatusDesc string `json:"status-desc"`
+	ExitCode   *int   `json:"exit-code,omitempty"`
+	Signal     *int   `json:"signal,omitempty"`
+}
+
+func toEndEventDto(entity db.CommandEntity) endEventDto {
+	return endEventDto{
+		Status:     string(entity.Status),
+		StatusDesc: entity.StatusDesc,
+		ExitCode:   entity.ExitCode,
+		Signal:     entity.Signal,
+	}
+}
+
+// savedOutput is saved output of one stream after the offset already sent to the client
+type savedOutput struct {
+	stream db.OutputStream
+	data   []byte
+	// end is the offset of the end of the stream
+	end int64
+}
+
+// getCommandUpdate reads the command and its output after offsets from db in separate transaction.
+// Output is read by offsets, because the replaced tail of the output leaves a gap in them.
+func getCommandUpdate(
+	ctx context.Context,
+	id uuid.UUID,
+	offsets map[db.OutputStream]int64,
+) (db.CommandEntity, []savedOutput, error) {
+	var entity db.CommandEntity
+	var outputs []savedOutput
+	err := doTransactional(ctx, func(tx pgx.Tx) error {
+		var err error
+		// status is read before the output, so output of the finished command is complete
+		entity, err = db.GetSingleCommandWithoutOutput(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		outputs = make([]savedOutput, 0, 2)
+		for _, stream := range []db.OutputStream{db.Stdout, db.Stderr} {
+			streams := []db.OutputStream{stream}
+			end, err := db.GetCommandOutputSize(ctx, tx, id, streams)
+			if err != nil {
+				return err
+			}
+			if end <= offsets[stream] {
+				continue
+			}
+			data, err := db.GetCommandOutputRange(ctx, tx, id, streams, offsets[stream], end)
+			if err != nil {
+				return err
+			}
+			outputs = append(outputs, savedOutput{stream: stream, data: data, end: end})
+		}
+		return tx.Commit(ctx)
+	})
+	return entity, outputs, err
+}
+
+// sseWriter writes Server-Sent Events and remembers how many bytes
+// of each stream were already sent to the client.
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	offsets map[db.OutputStream]int64
+	output  *outputEncoder
+}
+
+func (s *sseWriter) writeEvent(event string, data any) error {
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, bytes)
+	if err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// writeData sends encoded data of the stream, nothing is sent if it is empty
+func (s *sseWriter) writeData(stream db.OutputStream, data string) error {
+	if data == "" {
+		return nil
+	}
+	return s.writeEvent("output", outputEventDto{
+		Stream: string(stream),
+		Data:   data,
+	})
+}
+
+// writeOutput sends part of the data which was not sent yet. Data should end at the
+// position end of the stream. Returns false if some part of the stream
+// before data was not sent, so the output should be read again.
+func (s *sseWriter) writeOutput(stream db.OutputStream, data string, end int64) (bool, error) {
+	start := end - int64(len(data))
+	offset := s.offsets[stream]
+	if start > offset {
+		return false, nil
+	}
+	if end <= offset {
+		return true, nil
+	}
+	s.offsets[stream] = end
+	return true, s.writeData(stream, s.output.encode(stream, []byte(data[offset-start:])))
+}
+
+// writeEntity sends not yet sent output of the command read by getCommandUpdate.
+// If the command is not running, end event is also sent.
+func (s *sseWriter) writeEntity(entity db.CommandEntity, outputs []savedOutput) error {
+	for _, output := range outputs {
+		// output may be shorter than the range after the offset, if it contains a gap
+		s.offsets[output.stream] = output.end
+		err := s.writeData(output.stream, s.output.encode(output.stream, output.data))
+		if err != nil {
+			return err
+		}
+	}
+	if !entity.Status.Done() {
+		return nil
+	}
+	for _, stream := range []db.OutputStream{db.Stdout, db.Stderr} {
+		// incomplete rune at the end of the output is sent as is
+		err := s.writeData(stream, s.output.flush(stream))
+		if err != nil {
+			return err
+		}
+	}
+	return s.writeEvent("end", toEndEventDto(entity))
+}
+
+// cmdStreamHandler sends output of the command as Server-Sent Events.
+// Already saved output is sent first, then new chunks are sent as they appear.
+// The last event contains status of the command.
+func cmdStreamHandler(w http.ResponseWriter, r *http.Request) {
+	logger := getLogger(r)
+	encoder := json.NewEncoder(w)
+
+	s := mux.Vars(r)["id"]
+	id, err := uuid.Parse(s)
+	if err != nil {
+		logger.Printf("%s is invalid UUID: %s", s, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = encoder.Encode(errResponse{
+			ShortDesc: "Bad Request",
+			LongDesc:  fmt.Sprintf("Invalid url"),
+		})
+		return
+	}
+
+	encoding, err := parseEncodingParam(r)
+	if err != nil {
+		logger.Printf("bad query: %s", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = encoder.Encode(errResponse{
+			ShortDesc: "Bad Request",
+			LongDesc:  err.Error(),
+		})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		logger.Printf("streaming is not supported by response writer")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = encoder.Encode(errResponse{
+			ShortDesc: "Internal Server Error",
+		})
+		return
+	}
+
+	ctx := r.Context()
+	sse := &sseWriter{
+		w:       w,
+		flusher: flusher,
+		offsets: make(map[db.OutputStream]int64),
+		output:  newOutputEncoder(encoding),
+	}
+
+	headersSent := false
+	for {
+		// subscribe before reading from db, so no chunk is lost
+		events, unsubscribe := subscribe(id)
+		entity, outputs, err := getCommandUpdate(ctx, id, sse.offsets)
+		if err != nil {
+			unsubscribe()
+			logger.Printf("failed to get command info: %s", err)
+			if headersSent {
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if errors.Is(err, db.ErrEntityNotFound) {
+				w.WriteHeader(http.StatusNotFound)
+				_ = encoder.Encode(errResponse{
+					ShortDesc: "Not Found",
+					LongDesc:  "Entity with such id not found",
+				})
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = encoder.Encode(errResponse{
+				ShortDesc: "Internal Server Error",
+			})
+			return
+		}
+
+		if !headersSent {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+			flusher.Flush()
+			headersSent = true
+		}
+
+		err = sse.writeEntity(entity, outputs)
+		if err != nil || entity.Status.Done() {
+			unsubscribe()
+			logger.Printf("stream closed: %v", err)
+			return
+		}
+
+		done, err := forwardEvents(ctx, sse, events)
+		unsubscribe()
+		if err != nil {
+			logger.Printf("stream closed: %s", err)
+			return
+		}
+		if done {
+			// command is done, so the rest of output and status are sent from db
+			entity, outputs, err = getCommandUpdate(ctx, id, sse.offsets)
+			if err == nil {
+				err = sse.writeEntity(entity, outputs)
+			}
+			logger.Printf("stream closed: %v", err)
+			return
+		}
+		logger.Printf("subscription lagged, reading output again")
+	}
+}
+
+// forwardEvents sends output events to the client until the command is done.
+// Returns false if events should be resubscribed.
+func forwardEvents(ctx context.Context, sse *sseWriter, events <-chan executor.CmdEvent) (bool, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return false, errors.New("client disconnected")
+		case event, ok := <-events:
+			if !ok {
+				return false, nil
+			}
+			if event.Done {
+				return true, nil
+			}
+			inOrder, err := sse.writeOutput(event.Stream, event.Data, event.End)
+			if err != nil {
+				return false, err
+			}
+			if !inOrder {
+				return false, nil
+			}
+		}
+	}
+}
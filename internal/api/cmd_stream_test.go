@@ -0,0 +1,310 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"net/http"
+	"net/http/httptest"
+	"pg-test-task-2024/internal/config"
+	"pg-test-task-2024/internal/db"
+	"pg-test-task-2024/internal/db/dbtest"
+	"pg-test-task-2024/internal/executor"
+	"syscall"
+	"testing"
+)
+
+func TestCmdStream_WithBadUrl(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/v1/cmd/not-uuid/stream", nil)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(cmdStreamHandler)
+	req = mux.SetURLVars(req, map[string]string{
+		"id": "not-uuid",
+	})
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+	contentType := rr.Header().Get("Content-Type")
+	if contentType != "application/json" {
+		t.Fatalf("handler returned wrong content type: got %v want %v", contentType, "application/json")
+	}
+}
+
+func TestCmdStream_WithNoCmdInDB(t *testing.T) {
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to testcontainer db: %s", err)
+	}
+	doTransactional = db.TransactionWorkerProvider(pool)
+	subscribe = func(id uuid.UUID) (<-chan executor.CmdEvent, func()) {
+		return make(chan executor.CmdEvent), func() {}
+	}
+
+	id := uuid.New()
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/cmd/%s/stream", id), nil)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(cmdStreamHandler)
+	req = mux.SetURLVars(req, map[string]string{
+		"id": id.String(),
+	})
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+	contentType := rr.Header().Get("Content-Type")
+	if contentType != "application/json" {
+		t.Fatalf("handler returned wrong content type: got %v want %v", contentType, "application/json")
+	}
+}
+
+// TestCmdStream_WithRunningCmd checks that saved output is replayed,
+// already sent chunks are skipped and the end event is sent.
+func TestCmdStream_WithRunningCmd(t *testing.T) {
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to testcontainer db: %s", err)
+	}
+	doTransactional = db.TransactionWorkerProvider(pool)
+
+	var id uuid.UUID
+	err = doTransactional(ctx, func(tx pgx.Tx) error {
+		newId, err := db.InsertNewCommand(ctx, tx, correctScript, db.CommandOptions{})
+		if err != nil {
+			return err
+		}
+		id = newId
+		// only running command may be finished
+		_, err = db.DequeueCommand(ctx, tx)
+		if err != nil {
+			return err
+		}
+		_, err = db.AppendCommandOutput(ctx, tx, id, db.Stdout, []byte("hello "))
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		t.Fatalf("failed to insert test command into test db: %s", err)
+	}
+
+	events := make(chan executor.CmdEvent)
+	subscribe = func(id uuid.UUID) (<-chan executor.CmdEvent, func()) {
+		return events, func() {}
+	}
+	go func() {
+		// sending to unbuffered chan guarantees that handler already read the command
+		events <- executor.CmdEvent{Stream: db.Stdout, Data: "hello ", End: 6}
+		events <- executor.CmdEvent{Stream: db.Stdout, Data: "world", End: 11}
+		_ = doTransactional(ctx, func(tx pgx.Tx) error {
+			_, err := db.AppendCommandOutput(ctx, tx, id, db.Stdout, []byte("world"))
+			if err != nil {
+				return err
+			}
+			err = db.SetCommandFinished(ctx, tx, id, syscall.WaitStatus(0))
+			if err != nil {
+				return err
+			}
+			return tx.Commit(ctx)
+		})
+		events <- executor.CmdEvent{Done: true}
+	}()
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/cmd/%s/stream", id), nil)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(cmdStreamHandler)
+	req = mux.SetURLVars(req, map[string]string{
+		"id": id.String(),
+	})
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	contentType := rr.Header().Get("Content-Type")
+	if contentType != "text/event-stream" {
+		t.Fatalf("handler returned wrong content type: got %v want %v", contentType, "text/event-stream")
+	}
+
+	expectedBody := "event: output\ndata: {\"stream\":\"stdout\",\"data\":\"hello \"}\n\n" +
+		"event: output\ndata: {\"stream\":\"stdout\",\"data\":\"world\"}\n\n" +
+		"event: end\ndata: {\"status\":\"finished\",\"status-desc\":\"\",\"exit-code\":0}\n\n"
+	if rr.Body.String() != expectedBody {
+		t.Fatalf("bodies do not match:\ngot:\n%v\n----------\nexpected:\n%v\n", rr.Body.String(), expectedBody)
+	}
+}
+
+func TestCmdStream_KeepsRuneSplitBetweenChunks(t *testing.T) {
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to testcontainer db: %s", err)
+	}
+	doTransactional = db.TransactionWorkerProvider(pool)
+
+	// "é" is split between the saved chunk and the published one
+	var id uuid.UUID
+	err = doTransactional(ctx, func(tx pgx.Tx) error {
+		id, err = db.InsertNewCommand(ctx, tx, correctScript, db.CommandOptions{})
+		if err != nil {
+			return err
+		}
+		_, err = db.DequeueCommand(ctx, tx)
+		if err != nil {
+			return err
+		}
+		_, err = db.AppendCommandOutput(ctx, tx, id, db.Stdout, []byte("caf\xc3"))
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		t.Fatalf("failed to insert test command into test db: %s", err)
+	}
+
+	events := make(chan executor.CmdEvent)
+	subscribe = func(id uuid.UUID) (<-chan executor.CmdEvent, func()) {
+		return events, func() {}
+	}
+	go func() {
+		// sending to unbuffered chan guarantees that handler already read the command
+		events <- executor.CmdEvent{Stream: db.Stdout, Data: "caf\xc3", End: 4}
+		events <- executor.CmdEvent{Stream: db.Stdout, Data: "\xa9!", End: 6}
+		_ = doTransactional(ctx, func(tx pgx.Tx) error {
+			_, err := db.AppendCommandOutput(ctx, tx, id, db.Stdout, []byte("\xa9!"))
+			if err != nil {
+				return err
+			}
+			err = db.SetCommandFinished(ctx, tx, id, syscall.WaitStatus(0))
+			if err != nil {
+				return err
+			}
+			return tx.Commit(ctx)
+		})
+		events <- executor.CmdEvent{Done: true}
+	}()
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/cmd/%s/stream", id), nil)
+	rr := httptest.NewRecorder()
+	req = mux.SetURLVars(req, map[string]string{
+		"id": id.String(),
+	})
+
+	http.HandlerFunc(cmdStreamHandler).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	expectedBody := "event: output\ndata: {\"stream\":\"stdout\",\"data\":\"caf\"}\n\n" +
+		"event: output\ndata: {\"stream\":\"stdout\",\"data\":\"é!\"}\n\n" +
+		"event: end\ndata: {\"status\":\"finished\",\"status-desc\":\"\",\"exit-code\":0}\n\n"
+	if rr.Body.String() != expectedBody {
+		t.Fatalf("bodies do not match:\ngot:\n%v\n----------\nexpected:\n%v\n", rr.Body.String(), expectedBody)
+	}
+}
+
+func TestCmdStream_SendsReplacedTail(t *testing.T) {
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to testcontainer db: %s", err)
+	}
+	doTransactional = db.TransactionWorkerProvider(pool)
+
+	replaceTail := func(id uuid.UUID, counted int64, tail string) (int64, error) {
+		var ends []int64
+		err := doTransactional(ctx, func(tx pgx.Tx) error {
+			chunks := []db.OutputChunk{{Stream: db.Stdout, Data: []byte(tail), Tail: true}}
+			var err error
+			ends, err = db.ReplaceCommandOutputTail(ctx, tx, id, counted, chunks)
+			if err != nil {
+				return err
+			}
+			if counted != 0 {
+				err = db.SetCommandFinished(ctx, tx, id, syscall.WaitStatus(0))
+				if err != nil {
+					return err
+				}
+			}
+			return tx.Commit(ctx)
+		})
+		if err != nil {
+			return 0, err
+		}
+		return ends[0], nil
+	}
+
+	var id uuid.UUID
+	err = doTransactional(ctx, func(tx pgx.Tx) error {
+		newId, err := db.InsertNewCommand(ctx, tx, correctScript, db.CommandOptions{})
+		if err != nil {
+			return err
+		}
+		id = newId
+		// only running command may be finished
+		_, err = db.DequeueCommand(ctx, tx)
+		if err != nil {
+			return err
+		}
+		_, err = db.AppendCommandOutput(ctx, tx, id, db.Stdout, []byte("head "))
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+	if err == nil {
+		_, err = replaceTail(id, 0, "tail 1")
+	}
+	if err != nil {
+		t.Fatalf("failed to insert test command into test db: %s", err)
+	}
+
+	events := make(chan executor.CmdEvent)
+	subscribe = func(id uuid.UUID) (<-chan executor.CmdEvent, func()) {
+		return events, func() {}
+	}
+	go func() {
+		// the tail is replaced after the handler read the command, the final tail is published on exit
+		events <- executor.CmdEvent{Stream: db.Stdout, Data: "head ", End: 5}
+		end, err := replaceTail(id, 6, "tail 2")
+		if err == nil {
+			events <- executor.CmdEvent{Stream: db.Stdout, Data: "tail 2", End: end}
+		}
+		events <- executor.CmdEvent{Done: true}
+	}()
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/cmd/%s/stream", id), nil)
+	rr := httptest.NewRecorder()
+	req = mux.SetURLVars(req, map[string]string{
+		"id": id.String(),
+	})
+
+	http.HandlerFunc(cmdStreamHandler).ServeHTTP(rr, req)
+
+	expectedBody := "event: output\ndata: {\"stream\":\"stdout\",\"data\":\"head tail 1\"}\n\n" +
+		"event: output\ndata: {\"stream\":\"stdout\",\"data\":\"tail 2\"}\n\n" +
+		"event: end\ndata: {\"status\":\"finished\",\"status-desc\":\"\",\"exit-code\":0}\n\n"
+	if rr.Body.String() != expectedBody {
+		t.Fatalf("bodies do not match:\ngot:\n%v\n----------\nexpected:\n%v\n", rr.Body.String(), expectedBody)
+	}
+}
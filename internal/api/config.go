@@ -3,6 +3,7 @@ package api
 import (
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"io"
 	"net/http"
 	"pg-test-task-2024/internal/db"
 	"pg-test-task-2024/internal/executor"
@@ -10,22 +11,39 @@ import (
 
 var doTransactional db.TransactionWorker
 var submit executor.Submitter
-var cancelById func(id uuid.UUID) error
+var cancelById func(id uuid.UUID, req executor.CancelRequest) error
+var subscribe func(id uuid.UUID) (<-chan executor.CmdEvent, func())
+var attachStdin func(id uuid.UUID) (io.WriteCloser, func(), error)
 
-func ConfigureEndpoints(starter db.TransactionWorker, submitter executor.Submitter, cancelByIdFunc func(id uuid.UUID) error) *mux.Router {
+func ConfigureEndpoints(
+	starter db.TransactionWorker,
+	submitter executor.Submitter,
+	cancelByIdFunc func(id uuid.UUID, req executor.CancelRequest) error,
+	subscribeFunc func(id uuid.UUID) (<-chan executor.CmdEvent, func()),
+	attachStdinFunc func(id uuid.UUID) (io.WriteCloser, func(), error),
+) *mux.Router {
 	doTransactional = starter
 	submit = submitter
 	cancelById = cancelByIdFunc
+	subscribe = subscribeFunc
+	attachStdin = attachStdinFunc
 
 	r := mux.NewRouter()
 
 	r.NotFoundHandler = notFoundHandler{}
 	r.MethodNotAllowedHandler = methodNotAllowedHandler{}
+	r.Use(requestIdMiddleware)
 
 	r.HandleFunc("/api/v1/cmd", cmdReceiveHandler).Methods(http.MethodPost)
 	r.HandleFunc("/api/v1/cmd", getCmdListHandler).Methods(http.MethodGet)
 	r.HandleFunc("/api/v1/cmd/{id}", getSingleCmdHandler).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/cmd/{id}/output", cmdOutputHandler).Methods(http.MethodGet)
 	r.HandleFunc("/api/v1/cmd/{id}/cancel", cmdCancelHandler).Methods(http.MethodPatch)
+	r.HandleFunc("/api/v1/cmd/{id}/priority", cmdPriorityHandler).Methods(http.MethodPatch)
+	r.HandleFunc("/api/v1/cmd/{id}/stream", cmdStreamHandler).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/cmd/{id}/attach", cmdAttachHandler).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/cmd/{id}/artifacts", cmdArtifactsHandler).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/cmd/{id}/artifacts/{name}", cmdArtifactHandler).Methods(http.MethodGet)
 
 	return r
 }
@@ -1,55 +1,222 @@
 package api
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v4"
 	"net/http"
+	"net/url"
 	"pg-test-task-2024/internal/db"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
 )
 
 type cmdListDto struct {
 	CmdList []shortCmdDto `json:"cmd-list"`
+	// NextCursor is empty if there are no more commands
+	NextCursor string `json:"next-cursor,omitempty"`
 }
 
 type shortCmdDto struct {
-	Id         uuid.UUID `json:"id"`
-	Status     string    `json:"status"`
-	StatusDesc string    `json:"status-desc"`
-	ExitCode   *int      `json:"exit-code,omitempty"`
-	Signal     *int      `json:"signal,omitempty"`
+	Id         uuid.UUID  `json:"id"`
+	Status     string     `json:"status"`
+	StatusDesc string     `json:"status-desc"`
+	ExitCode   *int       `json:"exit-code,omitempty"`
+	Signal     *int       `json:"signal,omitempty"`
+	CreatedAt  time.Time  `json:"created-at"`
+	StartedAt  *time.Time `json:"started-at,omitempty"`
+	FinishedAt *time.Time `json:"finished-at,omitempty"`
+	Duration   string     `json:"duration,omitempty"`
+}
+
+// commandDuration returns duration of the command, for running command it is
+// the time passed since start till now. Empty string means that the command was not started.
+func commandDuration(entity db.CommandEntity, now time.Time) string {
+	if entity.StartedAt == nil {
+		return ""
+	}
+	end := now
+	if entity.FinishedAt != nil {
+		end = *entity.FinishedAt
+	}
+	return max(end.Sub(*entity.StartedAt), 0).Round(time.Millisecond).String()
 }
 
-func toShortCmdDto(entity db.CommandEntity) shortCmdDto {
+func toShortCmdDto(entity db.CommandEntity, now time.Time) shortCmdDto {
 	return shortCmdDto{
 		Id:         entity.Id,
 		Status:     string(entity.Status),
 		StatusDesc: entity.StatusDesc,
 		ExitCode:   entity.ExitCode,
 		Signal:     entity.Signal,
+		CreatedAt:  entity.CreatedAt,
+		StartedAt:  entity.StartedAt,
+		FinishedAt: entity.FinishedAt,
+		Duration:   commandDuration(entity, now),
 	}
 }
 
 func toCmdList(entities []db.CommandEntity) []shortCmdDto {
+	now := time.Now()
 	list := make([]shortCmdDto, 0, len(entities))
 	for _, entity := range entities {
-		list = append(list, toShortCmdDto(entity))
+		list = append(list, toShortCmdDto(entity, now))
 	}
 	return list
 }
 
+type cursorDto struct {
+	CreatedAt time.Time `json:"t"`
+	Id        uuid.UUID `json:"id"`
+}
+
+// encodeCursor makes opaque string from the cursor to pass it to the client
+func encodeCursor(cursor db.Cursor) string {
+	bytes, _ := json.Marshal(cursorDto{CreatedAt: cursor.CreatedAt, Id: cursor.Id})
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+func decodeCursor(s string) (db.Cursor, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return db.Cursor{}, err
+	}
+	var dto cursorDto
+	err = json.Unmarshal(bytes, &dto)
+	if err != nil {
+		return db.Cursor{}, err
+	}
+	return db.Cursor{CreatedAt: dto.CreatedAt, Id: dto.Id}, nil
+}
+
+func parseIntParam(query url.Values, name string) (*int, error) {
+	s := query.Get(name)
+	if s == "" {
+		return nil, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, fmt.Errorf("query parameter %s should be integer, got %s", name, s)
+	}
+	return &v, nil
+}
+
+func parseTimeParam(query url.Values, name string) (*time.Time, error) {
+	s := query.Get(name)
+	if s == "" {
+		return nil, nil
+	}
+	v, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, fmt.Errorf("query parameter %s should be time in RFC 3339 format, got %s", name, s)
+	}
+	return &v, nil
+}
+
+// parseCmdListFilter builds filter from query parameters. Returned error is suitable for client.
+func parseCmdListFilter(query url.Values) (db.CommandsFilter, error) {
+	filter := db.CommandsFilter{
+		Order: db.CreatedDesc,
+		Limit: defaultListLimit,
+	}
+
+	for _, status := range query["status"] {
+		switch db.CommandStatus(status) {
+		case db.Queued, db.Running, db.Error, db.Finished, db.Timeout, db.OutOfMemory:
+			filter.Statuses = append(filter.Statuses, db.CommandStatus(status))
+		default:
+			return db.CommandsFilter{}, fmt.Errorf("unknown status %s", status)
+		}
+	}
+
+	var err error
+	filter.ExitCode, err = parseIntParam(query, "exit-code")
+	if err != nil {
+		return db.CommandsFilter{}, err
+	}
+	filter.Signal, err = parseIntParam(query, "signal")
+	if err != nil {
+		return db.CommandsFilter{}, err
+	}
+	filter.CreatedAfter, err = parseTimeParam(query, "created-after")
+	if err != nil {
+		return db.CommandsFilter{}, err
+	}
+	filter.CreatedBefore, err = parseTimeParam(query, "created-before")
+	if err != nil {
+		return db.CommandsFilter{}, err
+	}
+
+	if sort := query.Get("sort"); sort != "" {
+		switch db.SortOrder(sort) {
+		case db.CreatedAsc, db.CreatedDesc:
+			filter.Order = db.SortOrder(sort)
+		default:
+			return db.CommandsFilter{}, fmt.Errorf("unknown sort %s, expected %s or %s", sort, db.CreatedAsc, db.CreatedDesc)
+		}
+	}
+
+	limit, err := parseIntParam(query, "limit")
+	if err != nil {
+		return db.CommandsFilter{}, err
+	}
+	if limit != nil {
+		if *limit < 1 || *limit > maxListLimit {
+			return db.CommandsFilter{}, fmt.Errorf("limit should be in range [1, %d]", maxListLimit)
+		}
+		filter.Limit = *limit
+	}
+
+	if s := query.Get("cursor"); s != "" {
+		cursor, err := decodeCursor(s)
+		if err != nil {
+			return db.CommandsFilter{}, errors.New("invalid cursor")
+		}
+		filter.After = &cursor
+	}
+	return filter, nil
+}
+
 func getCmdListHandler(w http.ResponseWriter, r *http.Request) {
 	logger := getLogger(r)
 	encoder := json.NewEncoder(w)
 
+	filter, err := parseCmdListFilter(r.URL.Query())
+	if err != nil {
+		logger.Printf("bad query: %s", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = encoder.Encode(errResponse{
+			ShortDesc: "Bad Request",
+			LongDesc:  err.Error(),
+		})
+		return
+	}
+	pageSize := filter.Limit
+	// one more record is requested to find out if there is a next page
+	filter.Limit += 1
+
 	ctx := r.Context()
-	var dtos []shortCmdDto
-	err := doTransactional(ctx, func(tx pgx.Tx) error {
-		entities, err := db.GetCommandsShortened(ctx, tx)
+	var rsp cmdListDto
+	err = doTransactional(ctx, func(tx pgx.Tx) error {
+		entities, err := db.GetCommandsShortened(ctx, tx, filter)
 		if err != nil {
 			return err
 		}
-		dtos = toCmdList(entities)
+		if len(entities) > pageSize {
+			entities = entities[:pageSize]
+			last := entities[len(entities)-1]
+			rsp.NextCursor = encodeCursor(db.Cursor{CreatedAt: last.CreatedAt, Id: last.Id})
+		}
+		rsp.CmdList = toCmdList(entities)
 		return tx.Commit(ctx)
 	})
 	if err != nil {
@@ -63,6 +230,6 @@ func getCmdListHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	_ = encoder.Encode(cmdListDto{CmdList: dtos})
-	logger.Printf("OK, send %v records", len(dtos))
+	_ = encoder.Encode(rsp)
+	logger.Printf("OK, send %v records", len(rsp.CmdList))
 }
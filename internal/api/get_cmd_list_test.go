@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
@@ -15,6 +16,76 @@ import (
 	"time"
 )
 
+var badListQueries = []string{
+	"status=unknown",
+	"exit-code=zero",
+	"signal=kill",
+	"created-after=yesterday",
+	"created-before=2024-13-01T00:00:00Z",
+	"sort=random",
+	"limit=0",
+	"limit=100000",
+	"cursor=not-a-cursor",
+}
+
+func TestGetCmdList_WithBadQueries(t *testing.T) {
+	for i, query := range badListQueries {
+		t.Run(fmt.Sprintf("bad query %v", i), func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/cmd?"+query, nil)
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(getCmdListHandler)
+
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusBadRequest {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+			}
+			contentType := rr.Header().Get("Content-Type")
+			if contentType != "application/json" {
+				t.Fatalf("handler returned wrong content type: got %v want %v", contentType, "application/json")
+			}
+		})
+	}
+}
+
+func TestCursor_EncodeDecode(t *testing.T) {
+	expected := db.Cursor{
+		CreatedAt: time.Date(2024, 5, 1, 12, 30, 15, 123456000, time.UTC),
+		Id:        uuid.New(),
+	}
+
+	got, err := decodeCursor(encodeCursor(expected))
+	if err != nil {
+		t.Fatalf("unexpected error decoding cursor: %v", err)
+	}
+	if !got.CreatedAt.Equal(expected.CreatedAt) || got.Id != expected.Id {
+		t.Fatalf("cursors do not match: got %v, expected %v", got, expected)
+	}
+}
+
+func TestCommandDuration(t *testing.T) {
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	finished := started.Add(1500 * time.Millisecond)
+	now := started.Add(time.Minute)
+
+	for _, tc := range []struct {
+		name     string
+		entity   db.CommandEntity
+		expected string
+	}{
+		{"queued", db.CommandEntity{}, ""},
+		{"running", db.CommandEntity{StartedAt: &started}, "1m0s"},
+		{"finished", db.CommandEntity{StartedAt: &started, FinishedAt: &finished}, "1.5s"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := commandDuration(tc.entity, now)
+			if got != tc.expected {
+				t.Fatalf("got duration %q, expected %q", got, tc.expected)
+			}
+		})
+	}
+}
+
 func TestGetCmdList_WithDBDown(t *testing.T) {
 	ctx := context.Background()
 	container := dbtest.CreateTestContainer(ctx, t)
@@ -58,7 +129,7 @@ func TestGetCmdList(t *testing.T) {
 
 	var id uuid.UUID
 	err = doTransactional(ctx, func(tx pgx.Tx) error {
-		newId, err := db.InsertNewCommand(ctx, tx, correctScript)
+		newId, err := db.InsertNewCommand(ctx, tx, correctScript, db.CommandOptions{})
 		if err != nil {
 			return err
 		}
@@ -97,8 +168,8 @@ func TestGetCmdList(t *testing.T) {
 	if gotDto.CmdList[0].Id != id {
 		t.Fatalf("ids do not match: got %v, expected %v", gotDto.CmdList[0].Id, id)
 	}
-	if gotDto.CmdList[0].Status != string(db.Running) {
-		t.Fatalf("status do not match: got %v, expected %v", gotDto.CmdList[0].Status, db.Running)
+	if gotDto.CmdList[0].Status != string(db.Queued) {
+		t.Fatalf("status do not match: got %v, expected %v", gotDto.CmdList[0].Status, db.Queued)
 	}
 	if gotDto.CmdList[0].StatusDesc != "" {
 		t.Fatalf("status do not match: got %v, expected %v", gotDto.CmdList[0].StatusDesc, "")
@@ -109,4 +180,78 @@ func TestGetCmdList(t *testing.T) {
 	if gotDto.CmdList[0].ExitCode != nil {
 		t.Fatalf("exit code do not match: got %v, expected nil", gotDto.CmdList[0].ExitCode)
 	}
+	if gotDto.CmdList[0].CreatedAt.IsZero() {
+		t.Fatalf("created-at should be set")
+	}
+	if gotDto.CmdList[0].StartedAt != nil || gotDto.CmdList[0].Duration != "" {
+		t.Fatalf("queued command should have no start time and duration: got %v, %q",
+			gotDto.CmdList[0].StartedAt, gotDto.CmdList[0].Duration)
+	}
+}
+
+func TestGetCmdList_WithPagination(t *testing.T) {
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to testcontainer db: %s", err)
+	}
+	doTransactional = db.TransactionWorkerProvider(pool)
+
+	numCommands := 5
+	ids := make([]uuid.UUID, 0, numCommands)
+	for i := 0; i < numCommands; i++ {
+		err = doTransactional(ctx, func(tx pgx.Tx) error {
+			newId, err := db.InsertNewCommand(ctx, tx, correctScript, db.CommandOptions{})
+			if err != nil {
+				return err
+			}
+			ids = append(ids, newId)
+			return tx.Commit(ctx)
+		})
+		if err != nil {
+			t.Fatalf("failed to insert test command into test db: %s", err)
+		}
+	}
+
+	gotIds := make([]uuid.UUID, 0, numCommands)
+	cursor := ""
+	for page := 0; ; page++ {
+		if page > numCommands {
+			t.Fatalf("too many pages")
+		}
+		req := httptest.NewRequest("GET", "/api/v1/cmd?sort=created-asc&limit=2&cursor="+cursor, nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(getCmdListHandler)
+
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+
+		decoder := json.NewDecoder(rr.Body)
+		var gotDto cmdListDto
+		err = decoder.Decode(&gotDto)
+		if err != nil {
+			t.Fatalf("failed to decode dto")
+		}
+		for _, dto := range gotDto.CmdList {
+			gotIds = append(gotIds, dto.Id)
+		}
+		if gotDto.NextCursor == "" {
+			break
+		}
+		cursor = gotDto.NextCursor
+	}
+
+	if len(gotIds) != numCommands {
+		t.Fatalf("got %d commands, expected %d", len(gotIds), numCommands)
+	}
+	for i := range ids {
+		if gotIds[i] != ids[i] {
+			t.Fatalf("ids at position %d do not match: got %v, expected %v", i, gotIds[i], ids[i])
+		}
+	}
 }
@@ -9,28 +9,145 @@ import (
 	"github.com/jackc/pgx/v4"
 	"net/http"
 	"pg-test-task-2024/internal/db"
+	"time"
 )
 
+// resourceUsageDto contains resource usage of the exited script
+type resourceUsageDto struct {
+	UserCPU                string `json:"user-cpu"`
+	SystemCPU              string `json:"system-cpu"`
+	MaxRSSKb               int64  `json:"max-rss-kb"`
+	InBlocks               int64  `json:"in-blocks"`
+	OutBlocks              int64  `json:"out-blocks"`
+	VoluntaryCtxSwitches   int64  `json:"voluntary-ctx-switches"`
+	InvoluntaryCtxSwitches int64  `json:"involuntary-ctx-switches"`
+}
+
+func toResourceUsageDto(usage *db.ResourceUsage) *resourceUsageDto {
+	if usage == nil {
+		return nil
+	}
+	return &resourceUsageDto{
+		UserCPU:                usage.UserCPU.String(),
+		SystemCPU:              usage.SystemCPU.String(),
+		MaxRSSKb:               usage.MaxRSS,
+		InBlocks:               usage.InBlocks,
+		OutBlocks:              usage.OutBlocks,
+		VoluntaryCtxSwitches:   usage.VoluntaryCtxSwitches,
+		InvoluntaryCtxSwitches: usage.InvoluntaryCtxSwitches,
+	}
+}
+
+// toResourceLimitsDto returns nil if no limit was requested
+func toResourceLimitsDto(limits db.ResourceLimits) *resourceLimitsDto {
+	if limits == (db.ResourceLimits{}) {
+		return nil
+	}
+	return &resourceLimitsDto{
+		Memory:   limits.Memory,
+		CPU:      limits.CPU,
+		Pids:     limits.Pids,
+		NoFile:   limits.NoFile,
+		FileSize: limits.FileSize,
+	}
+}
+
 type singleCmdDto struct {
 	Id         uuid.UUID `json:"id"`
 	Source     string    `json:"source"`
 	Status     string    `json:"status"`
 	StatusDesc string    `json:"status-desc"`
-	Output     string    `json:"output"`
-	ExitCode   *int      `json:"exit-code,omitempty"`
-	Signal     *int      `json:"signal,omitempty"`
+	// Output and Stderr are omitted if requested
+	Output     *string    `json:"output,omitempty"`
+	Stderr     *string    `json:"stderr,omitempty"`
+	Combined   *string    `json:"combined,omitempty"`
+	ExitCode   *int       `json:"exit-code,omitempty"`
+	Signal     *int       `json:"signal,omitempty"`
+	CreatedAt  time.Time  `json:"created-at"`
+	StartedAt  *time.Time `json:"started-at,omitempty"`
+	FinishedAt *time.Time `json:"finished-at,omitempty"`
+	// Duration is set if the command was started, for running command it is the time passed since start
+	Duration string `json:"duration,omitempty"`
+	// ResourceUsage is set after the script exits
+	ResourceUsage *resourceUsageDto `json:"resource-usage,omitempty"`
+	// Encoding of output fields, omitted for text
+	Encoding string `json:"encoding,omitempty"`
+	// OutputBytes is a size of the output written by the script, including not saved part
+	OutputBytes int64 `json:"output-bytes"`
+	// Truncated is true if some part of the output was not saved because of the limit
+	Truncated bool `json:"truncated"`
+	// QueuePosition is set if the command is queued, starts from 1
+	QueuePosition *int `json:"queue-position,omitempty"`
+	Priority      int  `json:"priority,omitempty"`
+	// CancelSignal and CancelEscalated are set if the command was canceled by the client
+	CancelSignal    *int              `json:"cancel-signal,omitempty"`
+	CancelEscalated *bool             `json:"cancel-escalated,omitempty"`
+	Timeout         string            `json:"timeout,omitempty"`
+	Args            []string          `json:"args,omitempty"`
+	Env             map[string]string `json:"env,omitempty"`
+	Stdin           *string           `json:"stdin,omitempty"`
+	OutputLimit     int64             `json:"output-limit,omitempty"`
+	OutputPolicy    string            `json:"output-policy,omitempty"`
+	// Limits are resource limits requested on submission
+	Limits *resourceLimitsDto `json:"limits,omitempty"`
+	// Sandbox is true if the script is executed in the sandbox
+	Sandbox bool `json:"sandbox"`
+	// RequestId is id of the request which submitted the command
+	RequestId string `json:"request-id,omitempty"`
+	// Workspace is a working directory of the script, set after the script starts
+	Workspace *string `json:"workspace,omitempty"`
 }
 
-func toSingleCmdDto(entity db.CommandEntity) singleCmdDto {
-	return singleCmdDto{
+// toSingleCmdDto converts entity to dto. Output is set only if withOutput is true,
+// combined output is set only if withCombined is also true. Output is encoded with encoding.
+func toSingleCmdDto(entity db.CommandEntity, withOutput, withCombined bool, encoding string) singleCmdDto {
+	dto := singleCmdDto{
 		Id:         entity.Id,
 		Source:     entity.Source,
 		Status:     string(entity.Status),
 		StatusDesc: entity.StatusDesc,
-		Output:     entity.Output,
 		ExitCode:   entity.ExitCode,
 		Signal:     entity.Signal,
+		Args:       entity.Args,
+		Env:        entity.Env,
+		Stdin:      entity.Stdin,
+		Priority:   entity.Priority,
+		CreatedAt:  entity.CreatedAt,
+		StartedAt:  entity.StartedAt,
+		FinishedAt: entity.FinishedAt,
+		Duration:   commandDuration(entity, time.Now()),
+
+		ResourceUsage: toResourceUsageDto(entity.Usage),
+
+		OutputBytes:  entity.OutputTotalBytes,
+		Truncated:    entity.OutputTruncated,
+		OutputLimit:  entity.OutputLimit,
+		OutputPolicy: string(entity.OutputPolicy),
+		Limits:       toResourceLimitsDto(entity.Limits),
+		Sandbox:      entity.Sandbox,
+		RequestId:    entity.RequestId,
+		Workspace:    entity.Workspace,
+	}
+	if entity.CancelSignal != nil {
+		dto.CancelSignal = entity.CancelSignal
+		dto.CancelEscalated = &entity.CancelEscalated
+	}
+	if entity.Timeout > 0 {
+		dto.Timeout = entity.Timeout.String()
+	}
+	if withOutput {
+		output := encodeOutput(entity.Output, encoding)
+		stderr := encodeOutput(entity.Stderr, encoding)
+		dto.Output, dto.Stderr = &output, &stderr
 	}
+	if withOutput && withCombined {
+		combined := encodeOutput(entity.Combined, encoding)
+		dto.Combined = &combined
+	}
+	if encoding != textEncoding {
+		dto.Encoding = encoding
+	}
+	return dto
 }
 
 func getSingleCmdHandler(w http.ResponseWriter, r *http.Request) {
@@ -50,14 +167,48 @@ func getSingleCmdHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var encoding string
+	var omitOutput bool
+	withCombined, err := parseBoolQueryParam(r, "combined")
+	if err == nil {
+		omitOutput, err = parseBoolQueryParam(r, "omit-output")
+	}
+	if err == nil {
+		encoding, err = parseEncodingParam(r)
+	}
+	if err != nil {
+		logger.Printf("bad query: %s", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = encoder.Encode(errResponse{
+			ShortDesc: "Bad Request",
+			LongDesc:  err.Error(),
+		})
+		return
+	}
+
 	ctx := r.Context()
 	var rsp singleCmdDto
 	err = doTransactional(ctx, func(tx pgx.Tx) error {
-		entity, err := db.GetSingleCommand(ctx, tx, id)
+		getCommand := db.GetSingleCommand
+		if omitOutput {
+			// output is not read from db at all, as it may be large
+			getCommand = db.GetSingleCommandWithoutOutput
+		}
+		entity, err := getCommand(ctx, tx, id)
 		if err != nil {
 			return err
 		}
-		rsp = toSingleCmdDto(entity)
+		rsp = toSingleCmdDto(entity, !omitOutput, withCombined, encoding)
+		if entity.Status == db.Queued {
+			// command may be started after it was read, so it is not found in the queue
+			position, err := db.GetQueuePosition(ctx, tx, id)
+			if err == nil {
+				rsp.QueuePosition = &position
+			} else if !errors.Is(err, db.ErrEntityNotFound) {
+				return err
+			}
+		}
 		return tx.Commit(ctx)
 	})
 	if err != nil {
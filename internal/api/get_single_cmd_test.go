@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
@@ -36,6 +37,46 @@ func TestGetSingleCmd_WithBadUrl(t *testing.T) {
 	}
 }
 
+func TestGetSingleCmd_WithBadCombinedParam(t *testing.T) {
+	id := uuid.New()
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/cmd/%s?combined=maybe", id), nil)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(getSingleCmdHandler)
+	req = mux.SetURLVars(req, map[string]string{
+		"id": id.String(),
+	})
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+	contentType := rr.Header().Get("Content-Type")
+	if contentType != "application/json" {
+		t.Fatalf("handler returned wrong content type: got %v want %v", contentType, "application/json")
+	}
+}
+
+func TestGetSingleCmd_WithBadEncodingParam(t *testing.T) {
+	id := uuid.New()
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/cmd/%s?encoding=hex", id), nil)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(getSingleCmdHandler)
+	req = mux.SetURLVars(req, map[string]string{
+		"id": id.String(),
+	})
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+	contentType := rr.Header().Get("Content-Type")
+	if contentType != "application/json" {
+		t.Fatalf("handler returned wrong content type: got %v want %v", contentType, "application/json")
+	}
+}
+
 func TestGetSingleCmd_WithDBDown(t *testing.T) {
 	ctx := context.Background()
 	container := dbtest.CreateTestContainer(ctx, t)
@@ -112,7 +153,7 @@ func TestGetSingleCmd_WithCmdInDB(t *testing.T) {
 
 	var id uuid.UUID
 	err = doTransactional(ctx, func(tx pgx.Tx) error {
-		newId, err := db.InsertNewCommand(ctx, tx, correctScript)
+		newId, err := db.InsertNewCommand(ctx, tx, correctScript, db.CommandOptions{})
 		if err != nil {
 			return err
 		}
@@ -153,15 +194,24 @@ func TestGetSingleCmd_WithCmdInDB(t *testing.T) {
 	if gotDto.Source != correctScript {
 		t.Fatalf("sources do not match: got %v, expected %v", gotDto.Source, correctScript)
 	}
-	if gotDto.Status != string(db.Running) {
-		t.Fatalf("status do not match: got %v, expected %v", gotDto.Status, db.Running)
+	if gotDto.Status != string(db.Queued) {
+		t.Fatalf("status do not match: got %v, expected %v", gotDto.Status, db.Queued)
+	}
+	if gotDto.QueuePosition == nil || *gotDto.QueuePosition != 1 {
+		t.Fatalf("queue position does not match: got %v, expected %v", gotDto.QueuePosition, 1)
 	}
 	if gotDto.StatusDesc != "" {
 		t.Fatalf("status do not match: got %v, expected %v", gotDto.StatusDesc, "")
 	}
-	if gotDto.Output != "" {
+	if gotDto.Output == nil || *gotDto.Output != "" {
 		t.Fatalf("outputs do not match: got %v, expected %v", gotDto.Output, "")
 	}
+	if gotDto.Stderr == nil || *gotDto.Stderr != "" {
+		t.Fatalf("stderrs do not match: got %v, expected %v", gotDto.Stderr, "")
+	}
+	if gotDto.Combined != nil {
+		t.Fatalf("combined output should be omitted: got %v", *gotDto.Combined)
+	}
 	if gotDto.Signal != nil {
 		t.Fatalf("signals do not match: got %v, expected nil", gotDto.Signal)
 	}
@@ -169,3 +219,265 @@ func TestGetSingleCmd_WithCmdInDB(t *testing.T) {
 		t.Fatalf("exit code do not match: got %v, expected nil", gotDto.ExitCode)
 	}
 }
+
+func TestGetSingleCmd_WithCombinedOutput(t *testing.T) {
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to testcontainer db: %s", err)
+	}
+	doTransactional = db.TransactionWorkerProvider(pool)
+
+	var id uuid.UUID
+	err = doTransactional(ctx, func(tx pgx.Tx) error {
+		newId, err := db.InsertNewCommand(ctx, tx, correctScript, db.CommandOptions{})
+		if err != nil {
+			return err
+		}
+		id = newId
+		for _, chunk := range []struct {
+			stream db.OutputStream
+			data   string
+		}{
+			{db.Stdout, "out 1\n"},
+			{db.Stderr, "err 1\n"},
+			{db.Stdout, "out 2\n"},
+		} {
+			_, err = db.AppendCommandOutput(ctx, tx, id, chunk.stream, []byte(chunk.data))
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		t.Fatalf("failed to insert test command into test db: %s", err)
+	}
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/cmd/%s?combined=true", id), nil)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(getSingleCmdHandler)
+	req = mux.SetURLVars(req, map[string]string{
+		"id": id.String(),
+	})
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	decoder := json.NewDecoder(rr.Body)
+	var gotDto singleCmdDto
+	err = decoder.Decode(&gotDto)
+	if err != nil {
+		t.Fatalf("failed to decode dto")
+	}
+
+	if gotDto.Output == nil {
+		t.Fatalf("output expected")
+	}
+	if *gotDto.Output != "out 1\nout 2\n" {
+		t.Fatalf("outputs do not match: got %q, expected %q", *gotDto.Output, "out 1\nout 2\n")
+	}
+	if gotDto.Stderr == nil {
+		t.Fatalf("stderr expected")
+	}
+	if *gotDto.Stderr != "err 1\n" {
+		t.Fatalf("stderrs do not match: got %q, expected %q", *gotDto.Stderr, "err 1\n")
+	}
+	if gotDto.Combined == nil {
+		t.Fatalf("combined output expected")
+	}
+	if *gotDto.Combined != "out 1\nerr 1\nout 2\n" {
+		t.Fatalf("combined outputs do not match: got %q, expected %q", *gotDto.Combined, "out 1\nerr 1\nout 2\n")
+	}
+}
+
+func TestGetSingleCmd_WithBinaryOutput(t *testing.T) {
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to testcontainer db: %s", err)
+	}
+	doTransactional = db.TransactionWorkerProvider(pool)
+
+	output := []byte("ok\x00\xff\xfe\n")
+	var id uuid.UUID
+	err = doTransactional(ctx, func(tx pgx.Tx) error {
+		newId, err := db.InsertNewCommand(ctx, tx, correctScript, db.CommandOptions{})
+		if err != nil {
+			return err
+		}
+		id = newId
+		_, err = db.AppendCommandOutput(ctx, tx, id, db.Stdout, output)
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		t.Fatalf("failed to insert test command into test db: %s", err)
+	}
+
+	for _, tc := range []struct {
+		encoding         string
+		expectedOutput   string
+		expectedEncoding string
+	}{
+		{"", "ok\x00\uFFFD\n", ""},
+		{"base64", base64.StdEncoding.EncodeToString(output), "base64"},
+	} {
+		t.Run(fmt.Sprintf("encoding=%s", tc.encoding), func(t *testing.T) {
+			req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/cmd/%s?encoding=%s", id, tc.encoding), nil)
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(getSingleCmdHandler)
+			req = mux.SetURLVars(req, map[string]string{
+				"id": id.String(),
+			})
+
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+			}
+			var gotDto singleCmdDto
+			err := json.NewDecoder(rr.Body).Decode(&gotDto)
+			if err != nil {
+				t.Fatalf("failed to decode dto")
+			}
+			if gotDto.Output == nil {
+				t.Fatalf("output expected")
+			}
+			if *gotDto.Output != tc.expectedOutput {
+				t.Fatalf("outputs do not match: got %q, expected %q", *gotDto.Output, tc.expectedOutput)
+			}
+			if gotDto.Encoding != tc.expectedEncoding {
+				t.Fatalf("encodings do not match: got %q, expected %q", gotDto.Encoding, tc.expectedEncoding)
+			}
+		})
+	}
+}
+
+func TestGetSingleCmd_WithOmittedOutput(t *testing.T) {
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to testcontainer db: %s", err)
+	}
+	doTransactional = db.TransactionWorkerProvider(pool)
+
+	var id uuid.UUID
+	err = doTransactional(ctx, func(tx pgx.Tx) error {
+		newId, err := db.InsertNewCommand(ctx, tx, correctScript, db.CommandOptions{})
+		if err != nil {
+			return err
+		}
+		id = newId
+		_, err = db.AppendCommandOutput(ctx, tx, id, db.Stdout, []byte("out\n"))
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		t.Fatalf("failed to insert test command into test db: %s", err)
+	}
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/cmd/%s?omit-output=true&combined=true", id), nil)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(getSingleCmdHandler)
+	req = mux.SetURLVars(req, map[string]string{
+		"id": id.String(),
+	})
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var gotDto singleCmdDto
+	err = json.NewDecoder(rr.Body).Decode(&gotDto)
+	if err != nil {
+		t.Fatalf("failed to decode dto")
+	}
+	if gotDto.Output != nil || gotDto.Stderr != nil || gotDto.Combined != nil {
+		t.Fatalf("output should be omitted: got %v, %v, %v", gotDto.Output, gotDto.Stderr, gotDto.Combined)
+	}
+	if gotDto.OutputBytes != 4 {
+		t.Fatalf("output size does not match: got %d, expected %d", gotDto.OutputBytes, 4)
+	}
+}
+
+func TestGetSingleCmd_WithResourceUsage(t *testing.T) {
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to testcontainer db: %s", err)
+	}
+	doTransactional = db.TransactionWorkerProvider(pool)
+
+	usage := db.ResourceUsage{
+		UserCPU:                1500 * time.Millisecond,
+		SystemCPU:              20 * time.Millisecond,
+		MaxRSS:                 10240,
+		InBlocks:               1,
+		OutBlocks:              8,
+		VoluntaryCtxSwitches:   12,
+		InvoluntaryCtxSwitches: 3,
+	}
+	var id uuid.UUID
+	err = doTransactional(ctx, func(tx pgx.Tx) error {
+		newId, err := db.InsertNewCommand(ctx, tx, correctScript, db.CommandOptions{})
+		if err != nil {
+			return err
+		}
+		id = newId
+		err = db.SetCommandResourceUsage(ctx, tx, id, usage)
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		t.Fatalf("failed to insert test command into test db: %s", err)
+	}
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/cmd/%s", id), nil)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(getSingleCmdHandler)
+	req = mux.SetURLVars(req, map[string]string{
+		"id": id.String(),
+	})
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var gotDto singleCmdDto
+	err = json.NewDecoder(rr.Body).Decode(&gotDto)
+	if err != nil {
+		t.Fatalf("failed to decode dto")
+	}
+	expected := resourceUsageDto{
+		UserCPU:                "1.5s",
+		SystemCPU:              "20ms",
+		MaxRSSKb:               10240,
+		InBlocks:               1,
+		OutBlocks:              8,
+		VoluntaryCtxSwitches:   12,
+		InvoluntaryCtxSwitches: 3,
+	}
+	if gotDto.ResourceUsage == nil || *gotDto.ResourceUsage != expected {
+		t.Fatalf("resource usage does not match: got %v, expected %v", gotDto.ResourceUsage, expected)
+	}
+}
@@ -2,14 +2,48 @@ package api
 
 import (
 	"fmt"
+	"github.com/google/uuid"
 	"log"
 	"net/http"
+	"regexp"
 )
 
+// requestIdHeader contains id of the request sent by the client or generated by the server
+const requestIdHeader = "X-Request-Id"
+
+var requestIdPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestIdMiddleware keeps valid request id sent by the client or generates new one.
+// The id is returned in the response header and written to logs.
+func requestIdMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIdHeader)
+		if !requestIdPattern.MatchString(id) {
+			id = uuid.NewString()
+			r.Header.Set(requestIdHeader, id)
+		}
+		w.Header().Set(requestIdHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// getRequestId returns id set by requestIdMiddleware, it is empty if the handler is called directly
+func getRequestId(r *http.Request) string {
+	id := r.Header.Get(requestIdHeader)
+	if !requestIdPattern.MatchString(id) {
+		return ""
+	}
+	return id
+}
+
 func getLogger(r *http.Request) *log.Logger {
 	defaultLogger := log.Default()
+	prefix := fmt.Sprintf("%s %s: ", r.Method, r.URL)
+	if id := getRequestId(r); id != "" {
+		prefix = fmt.Sprintf("[%s] %s", id, prefix)
+	}
 	return log.New(
 		defaultLogger.Writer(),
-		fmt.Sprintf("%s %s: ", r.Method, r.URL),
+		prefix,
 		defaultLogger.Flags()|log.Lmsgprefix)
 }
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestIdMiddleware(t *testing.T) {
+	var gotId string
+	handler := requestIdMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotId = getRequestId(r)
+	}))
+
+	for _, tc := range []struct {
+		sent string
+		kept bool
+	}{
+		{"client-id.1", true},
+		{"", false},
+		{"bad id\n", false},
+	} {
+		req := httptest.NewRequest("GET", "/api/v1/cmd", nil)
+		req.Header.Set(requestIdHeader, tc.sent)
+		rr := httptest.NewRecorder()
+
+		handler.ServeHTTP(rr, req)
+
+		if tc.kept && gotId != tc.sent {
+			t.Fatalf("got request id %q, expected %q", gotId, tc.sent)
+		}
+		if !tc.kept && (gotId == "" || gotId == tc.sent) {
+			t.Fatalf("got request id %q, expected new id instead of %q", gotId, tc.sent)
+		}
+		if header := rr.Header().Get(requestIdHeader); header != gotId {
+			t.Fatalf("got response header %q, expected %q", header, gotId)
+		}
+	}
+}
@@ -2,8 +2,12 @@ package config
 
 import (
 	"fmt"
+	"math"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func GetHost() string {
@@ -33,6 +37,30 @@ func GetCmdDir() string {
 	return s + "/"
 }
 
+// GetWorkspaceDir returns directory in which working directories of the commands are created
+func GetWorkspaceDir() string {
+	s := os.Getenv(workspaceDirEnv)
+	if s == "" {
+		return defaultWorkspaceDir
+	}
+	if strings.HasSuffix(s, "/") {
+		return s
+	}
+	return s + "/"
+}
+
+// GetArtifactsDir returns directory in which artifacts of the commands are stored
+func GetArtifactsDir() string {
+	s := os.Getenv(artifactsDirEnv)
+	if s == "" {
+		return defaultArtifactsDir
+	}
+	if strings.HasSuffix(s, "/") {
+		return s
+	}
+	return s + "/"
+}
+
 func GetDbConnStr() string {
 	s := os.Getenv(dbConnStrEnv)
 	if s == "" {
@@ -48,3 +76,263 @@ func GetMigrationsSource() string {
 	}
 	return s
 }
+
+// getDuration returns defaultValue if variable is not set
+func getDuration(env string, defaultValue time.Duration) time.Duration {
+	s := os.Getenv(env)
+	if s == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		panic(fmt.Errorf("%s should be non-negative duration, got %s", env, s))
+	}
+	return d
+}
+
+// GetWorkspaceRetention returns time for which working directory of the command is kept
+// after the command is done. 0 means that it is removed at once.
+func GetWorkspaceRetention() time.Duration {
+	return getDuration(workspaceRetentionEnv, 0)
+}
+
+// GetDefaultTimeout returns timeout for commands submitted without timeout.
+// 0 means no timeout.
+func GetDefaultTimeout() time.Duration {
+	return getDuration(defaultTimeoutEnv, 0)
+}
+
+// GetMaxTimeout returns max timeout which may be requested for command.
+// 0 means no limit.
+func GetMaxTimeout() time.Duration {
+	return getDuration(maxTimeoutEnv, 0)
+}
+
+// GetDefaultGracePeriod returns time given to the command to exit after
+// the signal requested on cancel, if the client did not specify it
+func GetDefaultGracePeriod() time.Duration {
+	return getDuration(defaultGracePeriodEnv, defaultGracePeriod)
+}
+
+// GetMaxGracePeriod returns max grace period which may be requested on cancel
+func GetMaxGracePeriod() time.Duration {
+	return getDuration(maxGracePeriodEnv, defaultMaxGracePeriod)
+}
+
+// getPositiveInt returns defaultValue if variable is not set
+func getPositiveInt(env string, defaultValue int) int {
+	s := os.Getenv(env)
+	if s == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		panic(fmt.Errorf("%s should be positive integer, got %s", env, s))
+	}
+	return n
+}
+
+// GetMaxRunningCmds returns max number of commands which may run at the same time,
+// other commands wait in the queue
+func GetMaxRunningCmds() int {
+	return getPositiveInt(maxRunningCmdsEnv, defaultMaxRunningCmds)
+}
+
+// GetOutputFlushInterval returns max time for which output of the command is buffered
+// before it is saved to db. 0 means that output is saved as soon as it is read.
+func GetOutputFlushInterval() time.Duration {
+	return getDuration(outputFlushIntervalEnv, defaultOutputFlushInterval)
+}
+
+// GetOutputFlushSize returns size of buffered output in bytes, after which it is saved to db
+func GetOutputFlushSize() int {
+	return getPositiveInt(outputFlushSizeEnv, defaultOutputFlushSize)
+}
+
+// GetMaxOutputSize returns max size of the saved output of one command in bytes.
+// It is used if the limit was not requested on submission.
+func GetMaxOutputSize() int {
+	return getPositiveInt(maxOutputSizeEnv, defaultMaxOutputSize)
+}
+
+// GetMaxArtifactSize returns max size of one artifact in bytes, larger artifacts are not stored
+func GetMaxArtifactSize() int {
+	return getPositiveInt(maxArtifactSizeEnv, defaultMaxArtifactSize)
+}
+
+// GetMaxArtifactsSize returns max total size of artifacts of one command in bytes
+func GetMaxArtifactsSize() int {
+	return getPositiveInt(maxArtifactsSizeEnv, defaultMaxArtifactsSize)
+}
+
+// GetMaxInputFiles returns max number of files which may be uploaded with one script
+func GetMaxInputFiles() int {
+	return getPositiveInt(maxInputFilesEnv, defaultMaxInputFiles)
+}
+
+// GetMaxInputFileSize returns max size of one file uploaded with the script in bytes
+func GetMaxInputFileSize() int {
+	return getPositiveInt(maxInputFileSizeEnv, defaultMaxInputFileSize)
+}
+
+// GetMaxInputFilesSize returns max total size of files uploaded with one script in bytes
+func GetMaxInputFilesSize() int {
+	return getPositiveInt(maxInputFilesSizeEnv, defaultMaxInputFilesSize)
+}
+
+// GetMaxRequestSize returns max size of the request to run the script in bytes,
+// files uploaded with the script are not counted
+func GetMaxRequestSize() int {
+	return getPositiveInt(maxRequestSizeEnv, defaultMaxRequestSize)
+}
+
+// getNonNegativeInt64 returns 0 if variable is not set
+func getNonNegativeInt64(env string) int64 {
+	s := os.Getenv(env)
+	if s == "" {
+		return 0
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		panic(fmt.Errorf("%s should be non-negative integer, got %s", env, s))
+	}
+	return n
+}
+
+// GetMemoryLimit returns max memory of the script and its descendants in bytes.
+// It is used if the limit was not requested on submission, greater limit may not be requested.
+// 0 means no limit. The same applies to the other resource limits.
+func GetMemoryLimit() int64 {
+	return getNonNegativeInt64(memoryLimitEnv)
+}
+
+// GetCPULimit returns max number of CPU cores used by the script, may be fractional
+func GetCPULimit() float64 {
+	s := os.Getenv(cpuLimitEnv)
+	if s == "" {
+		return 0
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 || math.IsNaN(n) || math.IsInf(n, 0) {
+		panic(fmt.Errorf("%s should be non-negative number, got %s", cpuLimitEnv, s))
+	}
+	return n
+}
+
+// GetPidsLimit returns max number of processes of the script
+func GetPidsLimit() int64 {
+	return getNonNegativeInt64(pidsLimitEnv)
+}
+
+// GetNoFileLimit returns max number of files opened by each process of the script
+func GetNoFileLimit() int64 {
+	return getNonNegativeInt64(noFileLimitEnv)
+}
+
+// GetFileSizeLimit returns max size of a file written by the script in bytes
+func GetFileSizeLimit() int64 {
+	return getNonNegativeInt64(fileSizeLimitEnv)
+}
+
+// GetCgroupRoot returns cgroup v2 directory delegated to the executor, cgroups of the commands
+// are created in it. Empty string means that cgroups are not used, so limits are applied
+// with rlimits where possible.
+func GetCgroupRoot() string {
+	return os.Getenv(cgroupRootEnv)
+}
+
+// ScriptUsers are credentials with which scripts are executed.
+// Each running script gets its own UID from UIDs while there are free ones.
+type ScriptUsers struct {
+	UIDs []uint32
+	GID  uint32
+}
+
+// parseID parses UID or GID
+func parseID(env, s string) uint32 {
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		panic(fmt.Errorf("%s should contain non-negative integers, got %s", env, s))
+	}
+	return uint32(id)
+}
+
+// GetScriptUsers returns nil if scripts are executed with credentials of the server.
+// UID may be a single number or a range, e.g. 2000-2009, GID is a single number.
+func GetScriptUsers() *ScriptUsers {
+	uid, gid := os.Getenv(scriptUIDEnv), os.Getenv(scriptGIDEnv)
+	if uid == "" && gid == "" {
+		return nil
+	}
+	if uid == "" || gid == "" {
+		panic(fmt.Errorf("%s and %s should be set together", scriptUIDEnv, scriptGIDEnv))
+	}
+	first, last, isRange := strings.Cut(uid, "-")
+	from := parseID(scriptUIDEnv, first)
+	to := from
+	if isRange {
+		to = parseID(scriptUIDEnv, last)
+	}
+	if to < from || to-from >= maxScriptUIDs {
+		panic(fmt.Errorf("%s should be a range from lower to higher UID of at most %d UIDs, got %s",
+			scriptUIDEnv, maxScriptUIDs, uid))
+	}
+	users := &ScriptUsers{GID: parseID(scriptGIDEnv, gid)}
+	for i := uint32(0); i <= to-from; i++ {
+		users.UIDs = append(users.UIDs, from+i)
+	}
+	return users
+}
+
+// GetSandboxDefault returns true if commands submitted without sandbox parameter
+// should be executed in the sandbox
+func GetSandboxDefault() bool {
+	s := os.Getenv(sandboxEnv)
+	if s == "" {
+		return false
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		panic(fmt.Errorf("%s should be boolean, got %s", sandboxEnv, s))
+	}
+	return v
+}
+
+// getList returns non-empty items of comma separated list, defaultValue is used if variable is not set
+func getList(env string, defaultValue string) []string {
+	s := os.Getenv(env)
+	if s == "" {
+		s = defaultValue
+	}
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// GetInterpreters returns absolute paths of interpreters which may be used in shebang
+func GetInterpreters() []string {
+	interpreters := getList(interpretersEnv, defaultInterpreters)
+	for _, interpreter := range interpreters {
+		if !filepath.IsAbs(interpreter) {
+			panic(fmt.Errorf("%s should contain absolute paths, got %s", interpretersEnv, interpreter))
+		}
+	}
+	return interpreters
+}
+
+// GetEnvAllowlist returns names of environment variables of the server, which are passed to scripts.
+// Name ending with * matches all variables with such prefix.
+func GetEnvAllowlist() []string {
+	names := getList(envAllowlistEnv, defaultEnvAllowlist)
+	for _, name := range names {
+		if strings.Contains(name, "=") {
+			panic(fmt.Errorf("%s should contain names of variables, got %s", envAllowlistEnv, name))
+		}
+	}
+	return names
+}
@@ -1,20 +1,67 @@
 package config
 
+import "time"
+
 const (
 	envPrefix = "EXECUTOR"
 )
 
 const (
-	hostEnv             = envPrefix + "_HOST"
-	portEnv             = envPrefix + "_PORT"
-	cmdDirEnv           = envPrefix + "_CMD_DIR"
-	dbConnStrEnv        = envPrefix + "_DB_CONN_STR"
-	migrationsSourceEnv = envPrefix + "_MIGRATIONS_SOURCE"
+	hostEnv                = envPrefix + "_HOST"
+	portEnv                = envPrefix + "_PORT"
+	cmdDirEnv              = envPrefix + "_CMD_DIR"
+	dbConnStrEnv           = envPrefix + "_DB_CONN_STR"
+	migrationsSourceEnv    = envPrefix + "_MIGRATIONS_SOURCE"
+	defaultTimeoutEnv      = envPrefix + "_DEFAULT_TIMEOUT"
+	maxTimeoutEnv          = envPrefix + "_MAX_TIMEOUT"
+	interpretersEnv        = envPrefix + "_INTERPRETERS"
+	defaultGracePeriodEnv  = envPrefix + "_DEFAULT_GRACE_PERIOD"
+	maxGracePeriodEnv      = envPrefix + "_MAX_GRACE_PERIOD"
+	maxRunningCmdsEnv      = envPrefix + "_MAX_RUNNING_CMDS"
+	outputFlushIntervalEnv = envPrefix + "_OUTPUT_FLUSH_INTERVAL"
+	outputFlushSizeEnv     = envPrefix + "_OUTPUT_FLUSH_SIZE"
+	maxOutputSizeEnv       = envPrefix + "_MAX_OUTPUT_SIZE"
+	memoryLimitEnv         = envPrefix + "_MEMORY_LIMIT"
+	cpuLimitEnv            = envPrefix + "_CPU_LIMIT"
+	pidsLimitEnv           = envPrefix + "_PIDS_LIMIT"
+	noFileLimitEnv         = envPrefix + "_NOFILE_LIMIT"
+	fileSizeLimitEnv       = envPrefix + "_FSIZE_LIMIT"
+	cgroupRootEnv          = envPrefix + "_CGROUP_ROOT"
+	scriptUIDEnv           = envPrefix + "_SCRIPT_UID"
+	scriptGIDEnv           = envPrefix + "_SCRIPT_GID"
+	sandboxEnv             = envPrefix + "_SANDBOX"
+	workspaceDirEnv        = envPrefix + "_WORKSPACE_DIR"
+	workspaceRetentionEnv  = envPrefix + "_WORKSPACE_RETENTION"
+	envAllowlistEnv        = envPrefix + "_ENV_ALLOWLIST"
+	artifactsDirEnv        = envPrefix + "_ARTIFACTS_DIR"
+	maxArtifactSizeEnv     = envPrefix + "_MAX_ARTIFACT_SIZE"
+	maxArtifactsSizeEnv    = envPrefix + "_MAX_ARTIFACTS_SIZE"
+	maxInputFilesEnv       = envPrefix + "_MAX_INPUT_FILES"
+	maxInputFileSizeEnv    = envPrefix + "_MAX_INPUT_FILE_SIZE"
+	maxInputFilesSizeEnv   = envPrefix + "_MAX_INPUT_FILES_SIZE"
+	maxRequestSizeEnv      = envPrefix + "_MAX_REQUEST_SIZE"
 )
 
 const (
-	defaultHost             = "0.0.0.0"
-	defaultPort             = "8081"
-	defaultCmdDir           = "/tmp/commands/"
-	defaultMigrationsSource = "file://scripts/migrations"
+	defaultHost                = "0.0.0.0"
+	defaultPort                = "8081"
+	defaultCmdDir              = "/tmp/commands/"
+	defaultWorkspaceDir        = "/tmp/workspaces/"
+	defaultArtifactsDir        = "/tmp/artifacts/"
+	defaultMigrationsSource    = "file://scripts/migrations"
+	defaultInterpreters        = "/bin/sh,/bin/bash,/usr/bin/python3"
+	defaultEnvAllowlist        = "PATH,LANG,LC_*,TZ"
+	defaultGracePeriod         = 10 * time.Second
+	defaultMaxGracePeriod      = 5 * time.Minute
+	defaultMaxRunningCmds      = 10
+	defaultOutputFlushInterval = 100 * time.Millisecond
+	defaultOutputFlushSize     = 64 * 1024
+	defaultMaxOutputSize       = 10 * 1024 * 1024
+	defaultMaxArtifactSize     = 100 * 1024 * 1024
+	defaultMaxArtifactsSize    = 500 * 1024 * 1024
+	defaultMaxInputFiles       = 100
+	defaultMaxInputFileSize    = 100 * 1024 * 1024
+	defaultMaxInputFilesSize   = 500 * 1024 * 1024
+	defaultMaxRequestSize      = 10 * 1024 * 1024
+	maxScriptUIDs              = 65536
 )
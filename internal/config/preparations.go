@@ -3,7 +3,21 @@ package config
 import "os"
 
 // PrepareCmdDir uses MkdirAll to create dir in which
-// files with scripts will be stored
+// files with scripts will be stored. If scripts are executed
+// as other users, they may open files in it by name,
+// but may not list, change or remove them.
 func PrepareCmdDir(path string) error {
-	return os.MkdirAll(path, 0700)
+	err := os.MkdirAll(path, 0700)
+	if err != nil {
+		return err
+	}
+	users := GetScriptUsers()
+	if users == nil {
+		return nil
+	}
+	err = os.Chown(path, -1, int(users.GID))
+	if err != nil {
+		return err
+	}
+	return os.Chmod(path, 0710)
 }
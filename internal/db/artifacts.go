@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+// AddCommandArtifacts saves descriptions of collected artifacts with one round-trip to db
+func AddCommandArtifacts(ctx context.Context, tx pgx.Tx, id uuid.UUID, artifacts []ArtifactEntity) error {
+	batch := &pgx.Batch{}
+	for _, artifact := range artifacts {
+		batch.Queue(`
+			INSERT INTO command_artifacts (command_id, name, size, sha256)
+				VALUES ($1, $2, $3, $4)
+			`, uuid.NullUUID{UUID: id, Valid: true}, artifact.Name, artifact.Size, artifact.Sha256)
+	}
+	results := tx.SendBatch(ctx, batch)
+	defer results.Close()
+	for range artifacts {
+		_, err := results.Exec()
+		if err != nil {
+			return err
+		}
+	}
+	return results.Close()
+}
+
+// GetCommandArtifacts returns artifacts of the command ordered by name.
+// ErrEntityNotFound is returned if there is no such command.
+func GetCommandArtifacts(ctx context.Context, tx pgx.Tx, id uuid.UUID) ([]ArtifactEntity, error) {
+	var exists bool
+	err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM commands WHERE id = $1)`,
+		uuid.NullUUID{UUID: id, Valid: true}).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrEntityNotFound
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT name, size, sha256, created_at FROM command_artifacts
+			WHERE command_id = $1
+			ORDER BY name
+		`, uuid.NullUUID{UUID: id, Valid: true})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	artifacts := make([]ArtifactEntity, 0)
+	for rows.Next() {
+		var artifact ArtifactEntity
+		err = rows.Scan(&artifact.Name, &artifact.Size, &artifact.Sha256, &artifact.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	return artifacts, rows.Err()
+}
+
+// GetCommandArtifact returns artifact of the command with such name.
+// ErrEntityNotFound is returned if there is no such artifact.
+func GetCommandArtifact(ctx context.Context, tx pgx.Tx, id uuid.UUID, name string) (ArtifactEntity, error) {
+	artifact := ArtifactEntity{Name: name}
+	err := tx.QueryRow(ctx, `
+		SELECT size, sha256, created_at FROM command_artifacts
+			WHERE command_id = $1 AND name = $2
+		`, uuid.NullUUID{UUID: id, Valid: true}, name).
+		Scan(&artifact.Size, &artifact.Sha256, &artifact.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ArtifactEntity{}, ErrEntityNotFound
+		}
+		return ArtifactEntity{}, err
+	}
+	return artifact, nil
+}
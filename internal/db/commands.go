@@ -6,13 +6,45 @@ import (
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v4"
 	"syscall"
+	"time"
 )
 
-func InsertNewCommand(ctx context.Context, tx pgx.Tx, source string) (uuid.UUID, error) {
+// nonNilArgs is used to store empty array instead of NULL
+func nonNilArgs(args []string) []string {
+	if args == nil {
+		return []string{}
+	}
+	return args
+}
+
+// nonNilEnv is used to store empty json object instead of NULL
+func nonNilEnv(env map[string]string) map[string]string {
+	if env == nil {
+		return map[string]string{}
+	}
+	return env
+}
+
+// policyOrDefault is used to store default policy instead of empty string
+func policyOrDefault(policy OutputPolicy) OutputPolicy {
+	if policy == "" {
+		return KeepHead
+	}
+	return policy
+}
+
+func InsertNewCommand(ctx context.Context, tx pgx.Tx, source string, opts CommandOptions) (uuid.UUID, error) {
 	var id uuid.NullUUID
 	err := tx.QueryRow(ctx, `
-		INSERT INTO commands (source, status) VALUES ($1, $2) RETURNING id
-		`, source, Running).Scan(&id)
+		INSERT INTO commands (source, status, interactive, timeout_ms, args, env, stdin, priority,
+				output_limit, output_policy, memory_limit, cpu_limit, pids_limit, nofile_limit, fsize_limit,
+				sandbox, request_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17) RETURNING id
+		`, source, Queued, opts.Interactive, opts.Timeout.Milliseconds(),
+		nonNilArgs(opts.Args), nonNilEnv(opts.Env), opts.Stdin, opts.Priority,
+		opts.OutputLimit, policyOrDefault(opts.OutputPolicy), opts.Limits.Memory, opts.Limits.CPU,
+		opts.Limits.Pids, opts.Limits.NoFile, opts.Limits.FileSize, opts.Sandbox,
+		opts.RequestId).Scan(&id)
 	if err != nil {
 		return uuid.Nil, err
 	}
@@ -22,65 +54,222 @@ func InsertNewCommand(ctx context.Context, tx pgx.Tx, source string) (uuid.UUID,
 	return id.UUID, nil
 }
 
+// SetCommandFinished saves exit code or signal of the command. Status is changed to finished
+// only if the command is running, so status set on cancellation or timeout is kept.
 func SetCommandFinished(ctx context.Context, tx pgx.Tx, id uuid.UUID, status syscall.WaitStatus) error {
 	var err error
 	if status.Exited() {
 		_, err = tx.Exec(ctx, `
-			UPDATE commands SET status = $1, exit_code = $2 
-				WHERE id = $3
-			`, Finished, status.ExitStatus(), uuid.NullUUID{UUID: id, Valid: true})
+			UPDATE commands SET status = CASE WHEN status = $1 THEN $2 ELSE status END, exit_code = $3,
+					finished_at = now()
+				WHERE id = $4
+			`, Running, Finished, status.ExitStatus(), uuid.NullUUID{UUID: id, Valid: true})
 	} else {
 		_, err = tx.Exec(ctx, `
-			UPDATE commands SET status = $1, signal = $2 
-				WHERE id = $3
-			`, Finished, status.Signal(), uuid.NullUUID{UUID: id, Valid: true})
+			UPDATE commands SET status = CASE WHEN status = $1 THEN $2 ELSE status END, signal = $3,
+					finished_at = now()
+				WHERE id = $4
+			`, Running, Finished, int(status.Signal()), uuid.NullUUID{UUID: id, Valid: true})
 	}
 	return err
 }
 
-func SetCommandFailed(ctx context.Context, tx pgx.Tx, id uuid.UUID, description string) error {
+// nullableUsage is used to read resource usage, which is NULL until the script exits
+type nullableUsage struct {
+	userCPU, systemCPU, maxRSS, inBlocks, outBlocks *int64
+	voluntaryCtxSwitches, involuntaryCtxSwitches    *int64
+}
+
+// toResourceUsage returns nil if usage was not saved. All columns are set together.
+func (u nullableUsage) toResourceUsage() *ResourceUsage {
+	if u.userCPU == nil || u.systemCPU == nil || u.maxRSS == nil || u.inBlocks == nil ||
+		u.outBlocks == nil || u.voluntaryCtxSwitches == nil || u.involuntaryCtxSwitches == nil {
+		return nil
+	}
+	return &ResourceUsage{
+		UserCPU:                time.Duration(*u.userCPU) * time.Microsecond,
+		SystemCPU:              time.Duration(*u.systemCPU) * time.Microsecond,
+		MaxRSS:                 *u.maxRSS,
+		InBlocks:               *u.inBlocks,
+		OutBlocks:              *u.outBlocks,
+		VoluntaryCtxSwitches:   *u.voluntaryCtxSwitches,
+		InvoluntaryCtxSwitches: *u.involuntaryCtxSwitches,
+	}
+}
+
+// SetCommandResourceUsage saves resource usage of the exited script
+func SetCommandResourceUsage(ctx context.Context, tx pgx.Tx, id uuid.UUID, usage ResourceUsage) error {
 	_, err := tx.Exec(ctx, `
-			UPDATE commands SET status = $1, status_desc = $2 
-				WHERE id = $3
-			`, Error, description, uuid.NullUUID{UUID: id, Valid: true})
+			UPDATE commands SET user_cpu_us = $1, system_cpu_us = $2, max_rss_kb = $3, in_blocks = $4,
+					out_blocks = $5, voluntary_ctx_switches = $6, involuntary_ctx_switches = $7
+				WHERE id = $8
+			`, usage.UserCPU.Microseconds(), usage.SystemCPU.Microseconds(), usage.MaxRSS, usage.InBlocks,
+		usage.OutBlocks, usage.VoluntaryCtxSwitches, usage.InvoluntaryCtxSwitches,
+		uuid.NullUUID{UUID: id, Valid: true})
 	return err
 }
 
-func AppendCommandOutput(ctx context.Context, tx pgx.Tx, id uuid.UUID, output string) error {
+// SetCommandWorkspace saves path of the working directory of the script
+func SetCommandWorkspace(ctx context.Context, tx pgx.Tx, id uuid.UUID, path string) error {
 	_, err := tx.Exec(ctx, `
-			UPDATE commands SET output = COALESCE(output, '') || $1
+			UPDATE commands SET workspace = $1
 				WHERE id = $2
-			`, output, uuid.NullUUID{UUID: id, Valid: true})
+			`, path, uuid.NullUUID{UUID: id, Valid: true})
+	return err
+}
+
+// SetCommandFailed marks the command failed. Status is changed only if the command is running,
+// so the status of the command which is already done is kept.
+func SetCommandFailed(ctx context.Context, tx pgx.Tx, id uuid.UUID, description string) error {
+	_, err := tx.Exec(ctx, `
+			UPDATE commands SET status = $1, status_desc = $2, finished_at = now()
+				WHERE id = $3 AND status = $4
+			`, Error, description, uuid.NullUUID{UUID: id, Valid: true}, Running)
+	return err
+}
+
+// SetCommandCanceled marks the command canceled by the client with the signal.
+// Status is changed only if the command is running, so the command finished before cancellation stays finished.
+func SetCommandCanceled(ctx context.Context, tx pgx.Tx, id uuid.UUID, description string, signal int) error {
+	_, err := tx.Exec(ctx, `
+			UPDATE commands SET status = $1, status_desc = $2, cancel_signal = $3, finished_at = now()
+				WHERE id = $4 AND status = $5
+			`, Error, description, signal, uuid.NullUUID{UUID: id, Valid: true}, Running)
+	return err
+}
+
+// SetCommandCancelEscalated records that the canceled command was killed after the grace period
+func SetCommandCancelEscalated(ctx context.Context, tx pgx.Tx, id uuid.UUID) error {
+	_, err := tx.Exec(ctx, `
+			UPDATE commands SET cancel_escalated = true
+				WHERE id = $1
+			`, uuid.NullUUID{UUID: id, Valid: true})
+	return err
+}
+
+// SetCommandOutOfMemory marks the command killed by OOM killer. Status is changed only
+// if the command is running or finished, so status set on cancellation or timeout is kept.
+func SetCommandOutOfMemory(ctx context.Context, tx pgx.Tx, id uuid.UUID, description string) error {
+	_, err := tx.Exec(ctx, `
+			UPDATE commands SET status = $1, status_desc = $2
+				WHERE id = $3 AND status IN ($4, $5)
+			`, OutOfMemory, description, uuid.NullUUID{UUID: id, Valid: true}, Running, Finished)
+	return err
+}
+
+// SetCommandTimedOut marks the command timed out. Status is changed only if the command is running.
+func SetCommandTimedOut(ctx context.Context, tx pgx.Tx, id uuid.UUID, description string) error {
+	_, err := tx.Exec(ctx, `
+			UPDATE commands SET status = $1, status_desc = $2, finished_at = now()
+				WHERE id = $3 AND status = $4
+			`, Timeout, description, uuid.NullUUID{UUID: id, Valid: true}, Running)
 	return err
 }
 
 func GetSingleCommand(ctx context.Context, tx pgx.Tx, id uuid.UUID) (CommandEntity, error) {
+	resEntity, err := GetSingleCommandWithoutOutput(ctx, tx, id)
+	if err != nil {
+		return CommandEntity{}, err
+	}
+	err = getCommandOutput(ctx, tx, &resEntity)
+	if err != nil {
+		return CommandEntity{}, err
+	}
+	return resEntity, nil
+}
+
+// GetSingleCommandWithoutOutput returns the command, output fields are not set
+func GetSingleCommandWithoutOutput(ctx context.Context, tx pgx.Tx, id uuid.UUID) (CommandEntity, error) {
 	var resEntity CommandEntity
+	var timeoutMs int64
+	var usage nullableUsage
 	err := tx.QueryRow(ctx, `
-		SELECT * FROM commands WHERE id = $1
+		SELECT id, source, status, status_desc, exit_code, signal, created_at, started_at, finished_at,
+			cancel_signal, cancel_escalated, output_total_bytes, output_truncated,
+			interactive, timeout_ms, args, env, stdin, priority, output_limit, output_policy,
+			memory_limit, cpu_limit, pids_limit, nofile_limit, fsize_limit, sandbox, request_id, workspace,
+			user_cpu_us, system_cpu_us, max_rss_kb, in_blocks, out_blocks,
+			voluntary_ctx_switches, involuntary_ctx_switches
+		FROM commands WHERE id = $1
 		`, uuid.NullUUID{UUID: id, Valid: true}).
 		Scan(
 			&resEntity.Id,
 			&resEntity.Source,
 			&resEntity.Status,
 			&resEntity.StatusDesc,
-			&resEntity.Output,
 			&resEntity.ExitCode,
-			&resEntity.Signal)
+			&resEntity.Signal,
+			&resEntity.CreatedAt,
+			&resEntity.StartedAt,
+			&resEntity.FinishedAt,
+			&resEntity.CancelSignal,
+			&resEntity.CancelEscalated,
+			&resEntity.OutputTotalBytes,
+			&resEntity.OutputTruncated,
+			&resEntity.Interactive,
+			&timeoutMs,
+			&resEntity.Args,
+			&resEntity.Env,
+			&resEntity.Stdin,
+			&resEntity.Priority,
+			&resEntity.OutputLimit,
+			&resEntity.OutputPolicy,
+			&resEntity.Limits.Memory,
+			&resEntity.Limits.CPU,
+			&resEntity.Limits.Pids,
+			&resEntity.Limits.NoFile,
+			&resEntity.Limits.FileSize,
+			&resEntity.Sandbox,
+			&resEntity.RequestId,
+			&resEntity.Workspace,
+			&usage.userCPU,
+			&usage.systemCPU,
+			&usage.maxRSS,
+			&usage.inBlocks,
+			&usage.outBlocks,
+			&usage.voluntaryCtxSwitches,
+			&usage.involuntaryCtxSwitches)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return CommandEntity{}, ErrEntityNotFound
 		}
 		return CommandEntity{}, err
 	}
+	resEntity.Timeout = time.Duration(timeoutMs) * time.Millisecond
+	resEntity.Usage = usage.toResourceUsage()
 	return resEntity, nil
 }
 
-func GetCommandsShortened(ctx context.Context, tx pgx.Tx) ([]CommandEntity, error) {
+// GetCommandOptions returns options with which the command was submitted
+func GetCommandOptions(ctx context.Context, tx pgx.Tx, id uuid.UUID) (CommandOptions, error) {
+	var opts CommandOptions
+	var timeoutMs int64
+	err := tx.QueryRow(ctx, `
+		SELECT interactive, timeout_ms, args, env, stdin, priority, output_limit, output_policy,
+			memory_limit, cpu_limit, pids_limit, nofile_limit, fsize_limit, sandbox, request_id
+		FROM commands WHERE id = $1
+		`, uuid.NullUUID{UUID: id, Valid: true}).
+		Scan(&opts.Interactive, &timeoutMs, &opts.Args, &opts.Env, &opts.Stdin, &opts.Priority,
+			&opts.OutputLimit, &opts.OutputPolicy, &opts.Limits.Memory, &opts.Limits.CPU,
+			&opts.Limits.Pids, &opts.Limits.NoFile, &opts.Limits.FileSize, &opts.Sandbox,
+			&opts.RequestId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return CommandOptions{}, ErrEntityNotFound
+		}
+		return CommandOptions{}, err
+	}
+	opts.Timeout = time.Duration(timeoutMs) * time.Millisecond
+	return opts, nil
+}
+
+// GetCommandsShortened returns commands without source and output, matching the filter.
+func GetCommandsShortened(ctx context.Context, tx pgx.Tx, filter CommandsFilter) ([]CommandEntity, error) {
+	clauses, args := filter.toSql()
 	rows, err := tx.Query(ctx, `
-		SELECT id, status, status_desc, exit_code, signal 
+		SELECT id, status, status_desc, exit_code, signal, created_at, started_at, finished_at
 		FROM commands
-		`)
+		`+clauses, args...)
 	if err != nil {
 		return []CommandEntity{}, err
 	}
@@ -93,11 +282,14 @@ func GetCommandsShortened(ctx context.Context, tx pgx.Tx) ([]CommandEntity, erro
 			&resEntity.Status,
 			&resEntity.StatusDesc,
 			&resEntity.ExitCode,
-			&resEntity.Signal)
+			&resEntity.Signal,
+			&resEntity.CreatedAt,
+			&resEntity.StartedAt,
+			&resEntity.FinishedAt)
 		if err != nil {
 			return []CommandEntity{}, err
 		}
 		entities = append(entities, resEntity)
 	}
-	return entities, nil
+	return entities, rows.Err()
 }
@@ -3,7 +3,48 @@ package db
 type CommandStatus string
 
 const (
+	// Queued commands wait for a free slot of the executor
+	Queued   CommandStatus = "queued"
 	Running  CommandStatus = "running"
 	Error    CommandStatus = "error"
 	Finished CommandStatus = "finished"
+	Timeout  CommandStatus = "timeout"
+	// OutOfMemory commands were killed by OOM killer because of the memory limit
+	OutOfMemory CommandStatus = "oom"
 )
+
+// Done returns true if the command is not queued or running, so its status will not change
+func (s CommandStatus) Done() bool {
+	return s != Queued && s != Running
+}
+
+// OutputStream is a stream of the script from which output was read
+type OutputStream string
+
+const (
+	Stdout OutputStream = "stdout"
+	Stderr OutputStream = "stderr"
+)
+
+// OutputPolicy describes which part of the output is saved if it exceeds the limit
+type OutputPolicy string
+
+const (
+	// KeepHead saves the beginning of the output, the rest is dropped
+	KeepHead OutputPolicy = "head"
+	// KeepTail saves the end of the output
+	KeepTail OutputPolicy = "tail"
+	// KeepHeadTail saves the beginning and the end of the output separated by a marker
+	KeepHeadTail OutputPolicy = "head-tail"
+	// KillOnLimit saves the beginning of the output and kills the script
+	KillOnLimit OutputPolicy = "kill"
+)
+
+// Valid returns true if p is one of known policies
+func (p OutputPolicy) Valid() bool {
+	switch p {
+	case KeepHead, KeepTail, KeepHeadTail, KillOnLimit:
+		return true
+	}
+	return false
+}
@@ -1,13 +1,114 @@
 package db
 
-import "github.com/google/uuid"
+import (
+	"github.com/google/uuid"
+	"time"
+)
 
 type CommandEntity struct {
 	Id         uuid.UUID
 	Source     string
 	Status     CommandStatus
 	StatusDesc string
-	Output     string
-	ExitCode   *int
-	Signal     *int
+	// Output and Stderr contain exact bytes written by the script, so they may be not valid UTF-8
+	Output []byte
+	Stderr []byte
+	// Combined contains stdout and stderr interleaved in the order they were read
+	Combined  []byte
+	ExitCode  *int
+	Signal    *int
+	CreatedAt time.Time
+	// StartedAt is nil if the command was not taken from the queue
+	StartedAt *time.Time
+	// FinishedAt is nil if the command has no final status yet
+	FinishedAt *time.Time
+	// CancelSignal is a signal requested on cancel, nil if the command was not canceled by the client
+	CancelSignal *int
+	// CancelEscalated is true if the canceled command was killed after the grace period
+	CancelEscalated bool
+	// OutputTotalBytes is a size of the output written by the script, including not saved part
+	OutputTotalBytes int64
+	// OutputTruncated is true if some part of the output was not saved because of the limit
+	OutputTruncated bool
+	// Usage is nil if the script has not exited yet
+	Usage *ResourceUsage
+	// Workspace is a path of the working directory of the script, nil if the command was not started
+	Workspace *string
+	CommandOptions
+}
+
+// ResourceUsage of the script and its descendants waited by it
+type ResourceUsage struct {
+	UserCPU   time.Duration
+	SystemCPU time.Duration
+	// MaxRSS is max resident set size in kilobytes
+	MaxRSS int64
+	// InBlocks and OutBlocks are numbers of block input and output operations
+	InBlocks  int64
+	OutBlocks int64
+	// VoluntaryCtxSwitches happen when the process waits for a resource,
+	// InvoluntaryCtxSwitches happen when the process is preempted
+	VoluntaryCtxSwitches   int64
+	InvoluntaryCtxSwitches int64
+}
+
+// OutputChunk is a part of the output read from one stream
+type OutputChunk struct {
+	Stream OutputStream
+	Data   []byte
+	// Marker is true if data is not written by the script, e.g. it marks truncated output.
+	// Such data is not counted in the total size of the output.
+	Marker bool
+	// Tail is true if the chunk is a part of the tail of the output,
+	// which is replaced when the tail is saved again
+	Tail bool
+}
+
+// ArtifactEntity describes file collected from the artifacts directory of the script
+type ArtifactEntity struct {
+	Name string
+	Size int64
+	// Sha256 is hex encoded SHA-256 of the content
+	Sha256    string
+	CreatedAt time.Time
+}
+
+// CommandOptions are set on submission and describe how the command should be executed
+type CommandOptions struct {
+	// Interactive is true if stdin of the script may be written by the client
+	Interactive bool
+	// Timeout is max duration of the command, 0 means no timeout
+	Timeout time.Duration
+	// Args are positional arguments of the script
+	Args []string
+	// Env contains additional environment variables of the script
+	Env map[string]string
+	// Stdin is written to stdin of the script, nil if there is no payload
+	Stdin *string
+	// Priority of the queued command, commands with higher priority are started first
+	Priority int
+	// OutputLimit is max size of the saved output in bytes, 0 means the server limit
+	OutputLimit int64
+	// OutputPolicy describes which part of the output is saved if it exceeds the limit
+	OutputPolicy OutputPolicy
+	// Limits are resource limits of the script requested by the client
+	Limits ResourceLimits
+	// Sandbox is true if the script is executed in its own namespaces with read-only root
+	Sandbox bool
+	// RequestId is id of the request which submitted the command, may be empty
+	RequestId string
+}
+
+// ResourceLimits of the script, 0 means the server limit
+type ResourceLimits struct {
+	// Memory is max memory of the script and its descendants in bytes
+	Memory int64
+	// CPU is max number of CPU cores, may be fractional
+	CPU float64
+	// Pids is max number of processes
+	Pids int64
+	// NoFile is max number of open files of each process
+	NoFile int64
+	// FileSize is max size of a file written by the script in bytes
+	FileSize int64
 }
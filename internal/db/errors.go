@@ -5,4 +5,5 @@ import "errors"
 var (
 	ErrInvalidUUID    = errors.New("invalid UUID")
 	ErrEntityNotFound = errors.New("entity not found")
+	ErrUnknownStream  = errors.New("unknown output stream")
 )
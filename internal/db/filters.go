@@ -0,0 +1,95 @@
+package db
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"strings"
+	"time"
+)
+
+// SortOrder defines order of commands in list. Commands are always
+// sorted by (created_at, id), so the order is stable.
+type SortOrder string
+
+const (
+	CreatedAsc  SortOrder = "created-asc"
+	CreatedDesc SortOrder = "created-desc"
+)
+
+// Cursor points to the last command of the previous page
+type Cursor struct {
+	CreatedAt time.Time
+	Id        uuid.UUID
+}
+
+// CommandsFilter describes which commands should be listed.
+// Nil and empty fields are not used for filtering.
+type CommandsFilter struct {
+	Statuses      []CommandStatus
+	ExitCode      *int
+	Signal        *int
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+
+	// After is a cursor, only commands after it (in terms of Order) are listed
+	After *Cursor
+	Order SortOrder
+	Limit int
+}
+
+// toSql builds WHERE, ORDER BY and LIMIT clauses of the query and their arguments.
+func (f CommandsFilter) toSql() (string, []any) {
+	conditions := make([]string, 0)
+	args := make([]any, 0)
+	addCondition := func(format string, values ...any) {
+		placeholders := make([]any, 0, len(values))
+		for _, v := range values {
+			args = append(args, v)
+			placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+		}
+		conditions = append(conditions, fmt.Sprintf(format, placeholders...))
+	}
+
+	if len(f.Statuses) != 0 {
+		statuses := make([]string, 0, len(f.Statuses))
+		for _, status := range f.Statuses {
+			statuses = append(statuses, string(status))
+		}
+		addCondition("status = ANY(%s)", statuses)
+	}
+	if f.ExitCode != nil {
+		addCondition("exit_code = %s", *f.ExitCode)
+	}
+	if f.Signal != nil {
+		addCondition("signal = %s", *f.Signal)
+	}
+	if f.CreatedAfter != nil {
+		addCondition("created_at >= %s", *f.CreatedAfter)
+	}
+	if f.CreatedBefore != nil {
+		addCondition("created_at < %s", *f.CreatedBefore)
+	}
+
+	direction := "DESC"
+	comparison := "<"
+	if f.Order == CreatedAsc {
+		direction = "ASC"
+		comparison = ">"
+	}
+	if f.After != nil {
+		addCondition("(created_at, id) "+comparison+" (%s, %s)",
+			f.After.CreatedAt, uuid.NullUUID{UUID: f.After.Id, Valid: true})
+	}
+
+	var sb strings.Builder
+	if len(conditions) != 0 {
+		sb.WriteString("WHERE ")
+		sb.WriteString(strings.Join(conditions, " AND "))
+	}
+	sb.WriteString(fmt.Sprintf("\nORDER BY created_at %s, id %s", direction, direction))
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		sb.WriteString(fmt.Sprintf("\nLIMIT $%d", len(args)))
+	}
+	return sb.String(), args
+}
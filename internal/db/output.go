@@ -0,0 +1,320 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+// appendOutputQuery returns query which saves data as the next output chunk of the command
+// and returns the length of the stream. Query takes id, stream, length of data, data,
+// number of bytes added to the total size of the output and whether the chunk is a part of the tail.
+func appendOutputQuery(stream OutputStream) (string, error) {
+	var lengthColumn string
+	switch stream {
+	case Stdout:
+		lengthColumn = "stdout_bytes"
+	case Stderr:
+		lengthColumn = "stderr_bytes"
+	default:
+		return "", ErrUnknownStream
+	}
+	// row of the command is locked by update, so chunks of the command
+	// appended in concurrent transactions get different sequence numbers
+	return fmt.Sprintf(`
+		WITH cmd AS (
+			UPDATE commands SET output_seq = output_seq + 1, %[1]s = %[1]s + $3,
+					output_total_bytes = output_total_bytes + $5
+				WHERE id = $1
+				RETURNING output_seq, %[1]s AS stream_end, stdout_bytes + stderr_bytes AS output_end
+		)
+		INSERT INTO command_output_chunks (command_id, seq, stream, stream_offset, output_offset, data, tail)
+			SELECT $1, output_seq, $2, stream_end - $3, output_end - $3, $4, $6 FROM cmd
+			RETURNING stream_offset + $3
+		`, lengthColumn), nil
+}
+
+// AppendCommandOutput saves data as the next output chunk of the command.
+// Returns the length of the stream in bytes after data was appended.
+func AppendCommandOutput(ctx context.Context, tx pgx.Tx, id uuid.UUID, stream OutputStream, data []byte) (int64, error) {
+	query, err := appendOutputQuery(stream)
+	if err != nil {
+		return 0, err
+	}
+	var length int64
+	err = tx.QueryRow(ctx, query,
+		uuid.NullUUID{UUID: id, Valid: true}, string(stream), int64(len(data)), data, int64(len(data)), false).
+		Scan(&length)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrEntityNotFound
+		}
+		return 0, err
+	}
+	return length, nil
+}
+
+// AppendCommandOutputChunks saves chunks in order with one round-trip to db.
+// Returns the length of the stream of each chunk in bytes after the chunk was appended.
+func AppendCommandOutputChunks(ctx context.Context, tx pgx.Tx, id uuid.UUID, chunks []OutputChunk) ([]int64, error) {
+	batch := &pgx.Batch{}
+	for _, chunk := range chunks {
+		query, err := appendOutputQuery(chunk.Stream)
+		if err != nil {
+			return nil, err
+		}
+		counted := int64(len(chunk.Data))
+		if chunk.Marker {
+			counted = 0
+		}
+		batch.Queue(query,
+			uuid.NullUUID{UUID: id, Valid: true}, string(chunk.Stream), int64(len(chunk.Data)), chunk.Data, counted,
+			chunk.Tail)
+	}
+
+	results := tx.SendBatch(ctx, batch)
+	defer results.Close()
+	lengths := make([]int64, len(chunks))
+	for i := range chunks {
+		err := results.QueryRow().Scan(&lengths[i])
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, ErrEntityNotFound
+			}
+			return nil, err
+		}
+	}
+	return lengths, results.Close()
+}
+
+// ReplaceCommandOutputTail deletes the previously saved tail of the output and saves chunks
+// as the new tail. counted is a number of bytes of the previous tail counted in the total size
+// of the output, it is subtracted from the total size.
+// Lengths of the streams are not decreased, the new tail is appended after the deleted one,
+// so offsets only grow and there is a gap in place of the deleted tail.
+// Returns the length of the stream of each chunk in bytes after the chunk was appended.
+func ReplaceCommandOutputTail(
+	ctx context.Context,
+	tx pgx.Tx,
+	id uuid.UUID,
+	counted int64,
+	chunks []OutputChunk,
+) ([]int64, error) {
+	tag, err := tx.Exec(ctx, `
+		UPDATE commands SET output_total_bytes = output_total_bytes - $2 WHERE id = $1
+		`, uuid.NullUUID{UUID: id, Valid: true}, counted)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrEntityNotFound
+	}
+	_, err = tx.Exec(ctx, `
+		DELETE FROM command_output_chunks WHERE command_id = $1 AND tail
+		`, uuid.NullUUID{UUID: id, Valid: true})
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		return nil, nil
+	}
+	return AppendCommandOutputChunks(ctx, tx, id, chunks)
+}
+
+// AddCommandDroppedOutput records that dropped bytes of the output were not saved because of the limit
+func AddCommandDroppedOutput(ctx context.Context, tx pgx.Tx, id uuid.UUID, dropped int64) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE commands SET output_total_bytes = output_total_bytes + $1, output_truncated = true
+			WHERE id = $2
+		`, dropped, uuid.NullUUID{UUID: id, Valid: true})
+	return err
+}
+
+// getCommandOutput reassembles output of the command from chunks
+func getCommandOutput(ctx context.Context, tx pgx.Tx, entity *CommandEntity) error {
+	rows, err := tx.Query(ctx, `
+		SELECT stream, data FROM command_output_chunks
+			WHERE command_id = $1
+			ORDER BY seq
+		`, uuid.NullUUID{UUID: entity.Id, Valid: true})
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var stdout, stderr, combined bytes.Buffer
+	for rows.Next() {
+		var stream OutputStream
+		var data []byte
+		err = rows.Scan(&stream, &data)
+		if err != nil {
+			return err
+		}
+		switch stream {
+		case Stdout:
+			stdout.Write(data)
+		case Stderr:
+			stderr.Write(data)
+		default:
+			return ErrUnknownStream
+		}
+		combined.Write(data)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	entity.Output = stdout.Bytes()
+	entity.Stderr = stderr.Bytes()
+	entity.Combined = combined.Bytes()
+	return nil
+}
+
+// offsetColumn returns column with offsets of the chunks in the output of the given streams
+func offsetColumn(streams []OutputStream) string {
+	if len(streams) == 1 {
+		return "stream_offset"
+	}
+	return "output_offset"
+}
+
+// streamNames converts streams to the query parameter
+func streamNames(streams []OutputStream) []string {
+	names := make([]string, 0, len(streams))
+	for _, stream := range streams {
+		names = append(names, string(stream))
+	}
+	return names
+}
+
+// GetCommandOutputSize returns the end offset of the saved output of the given streams in bytes.
+// If the tail of the output was replaced, it is greater than the size of the saved output.
+func GetCommandOutputSize(ctx context.Context, tx pgx.Tx, id uuid.UUID, streams []OutputStream) (int64, error) {
+	var stdoutBytes, stderrBytes int64
+	err := tx.QueryRow(ctx, `
+		SELECT stdout_bytes, stderr_bytes FROM commands WHERE id = $1
+		`, uuid.NullUUID{UUID: id, Valid: true}).Scan(&stdoutBytes, &stderrBytes)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrEntityNotFound
+		}
+		return 0, err
+	}
+
+	var size int64
+	for _, stream := range streams {
+		switch stream {
+		case Stdout:
+			size += stdoutBytes
+		case Stderr:
+			size += stderrBytes
+		default:
+			return 0, ErrUnknownStream
+		}
+	}
+	return size, nil
+}
+
+// GetCommandOutputRange returns saved bytes from start to end (exclusive) of the output of the given streams.
+// Chunks of several streams are interleaved in the order they were read. Offsets of the replaced
+// tail are skipped, so fewer bytes than requested may be returned.
+func GetCommandOutputRange(
+	ctx context.Context,
+	tx pgx.Tx,
+	id uuid.UUID,
+	streams []OutputStream,
+	start, end int64,
+) ([]byte, error) {
+	rows, err := tx.Query(ctx, fmt.Sprintf(`
+		SELECT %[1]s, data FROM command_output_chunks
+			WHERE command_id = $1 AND stream = ANY($2) AND %[1]s < $4 AND %[1]s + length(data) > $3
+			ORDER BY seq
+		`, offsetColumn(streams)), uuid.NullUUID{UUID: id, Valid: true}, streamNames(streams), start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var output bytes.Buffer
+	for rows.Next() {
+		var offset int64
+		var data []byte
+		err = rows.Scan(&offset, &data)
+		if err != nil {
+			return nil, err
+		}
+		from := max(start-offset, 0)
+		to := min(end-offset, int64(len(data)))
+		output.Write(data[from:to])
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
+
+// GetCommandOutputTail returns last lines of the output of the given streams and the offset
+// of the end of returned data.
+// Line break at the end of the output does not start a new line.
+func GetCommandOutputTail(
+	ctx context.Context,
+	tx pgx.Tx,
+	id uuid.UUID,
+	streams []OutputStream,
+	lines int,
+) ([]byte, int64, error) {
+	// size is computed in the same query, so chunks appended concurrently are not counted
+	rows, err := tx.Query(ctx, fmt.Sprintf(`
+		SELECT data, MAX(%[1]s + length(data)) OVER () FROM command_output_chunks
+			WHERE command_id = $1 AND stream = ANY($2)
+			ORDER BY seq DESC
+		`, offsetColumn(streams)), uuid.NullUUID{UUID: id, Valid: true}, streamNames(streams))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	// chunks are read from the end until enough line breaks are found
+	parts := make([][]byte, 0)
+	lineBreaks := 0
+	atEnd := true
+	var size int64
+	for lineBreaks < lines && rows.Next() {
+		var data []byte
+		err = rows.Scan(&data, &size)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		i := len(data)
+		if atEnd && data[i-1] == '\n' {
+			i--
+		}
+		atEnd = false
+		for lineBreaks < lines {
+			j := bytes.LastIndexByte(data[:i], '\n')
+			if j < 0 {
+				break
+			}
+			lineBreaks++
+			i = j
+			if lineBreaks == lines {
+				data = data[j+1:]
+			}
+		}
+		parts = append(parts, data)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var output bytes.Buffer
+	for i := len(parts) - 1; i >= 0; i-- {
+		output.Write(parts[i])
+	}
+	return output.Bytes(), size, nil
+}
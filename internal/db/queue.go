@@ -0,0 +1,86 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+// DequeueCommand marks the queued command with the highest priority running and returns its id.
+// Commands with the same priority are taken in order of submission.
+// Returns ErrEntityNotFound if there are no queued commands.
+func DequeueCommand(ctx context.Context, tx pgx.Tx) (uuid.UUID, error) {
+	var id uuid.NullUUID
+	err := tx.QueryRow(ctx, `
+		UPDATE commands SET status = $1, started_at = now()
+			WHERE id = (
+				SELECT id FROM commands WHERE status = $2
+					ORDER BY priority DESC, created_at, id
+					LIMIT 1
+					FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id
+		`, Running, Queued).Scan(&id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return uuid.Nil, ErrEntityNotFound
+		}
+		return uuid.Nil, err
+	}
+	if !id.Valid {
+		return uuid.Nil, ErrInvalidUUID
+	}
+	return id.UUID, nil
+}
+
+// SetQueuedCommandFailed sets error status of the command only if it is queued.
+// Returns ErrEntityNotFound if there is no such queued command.
+func SetQueuedCommandFailed(ctx context.Context, tx pgx.Tx, id uuid.UUID, description string) error {
+	tag, err := tx.Exec(ctx, `
+		UPDATE commands SET status = $1, status_desc = $2, finished_at = now()
+			WHERE id = $3 AND status = $4
+		`, Error, description, uuid.NullUUID{UUID: id, Valid: true}, Queued)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrEntityNotFound
+	}
+	return nil
+}
+
+// GetQueuePosition returns position of the queued command starting from 1
+// considering priorities of queued commands.
+// Returns ErrEntityNotFound if there is no such queued command.
+func GetQueuePosition(ctx context.Context, tx pgx.Tx, id uuid.UUID) (int, error) {
+	var position int
+	err := tx.QueryRow(ctx, `
+		SELECT count(*) FROM commands AS queued, commands AS cmd
+			WHERE cmd.id = $1 AND cmd.status = $2 AND queued.status = $2
+				AND (-queued.priority, queued.created_at, queued.id) <= (-cmd.priority, cmd.created_at, cmd.id)
+		`, uuid.NullUUID{UUID: id, Valid: true}, Queued).Scan(&position)
+	if err != nil {
+		return 0, err
+	}
+	if position == 0 {
+		return 0, ErrEntityNotFound
+	}
+	return position, nil
+}
+
+// SetQueuedCommandPriority changes priority of the command only if it is queued.
+// Returns ErrEntityNotFound if there is no such queued command.
+func SetQueuedCommandPriority(ctx context.Context, tx pgx.Tx, id uuid.UUID, priority int) error {
+	tag, err := tx.Exec(ctx, `
+		UPDATE commands SET priority = $1
+			WHERE id = $2 AND status = $3
+		`, priority, uuid.NullUUID{UUID: id, Valid: true}, Queued)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrEntityNotFound
+	}
+	return nil
+}
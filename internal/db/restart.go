@@ -9,7 +9,7 @@ import (
 func MarkRunningCmdsError(ctx context.Context, tx pgx.Tx) ([]uuid.UUID, error) {
 	rows, err := tx.Query(ctx, `
 		UPDATE commands
-		SET status = 'error', status_desc = 'server got down' 
+		SET status = 'error', status_desc = 'server got down', finished_at = now()
 		WHERE status = 'running'
 		RETURNING id`)
 	if err != nil {
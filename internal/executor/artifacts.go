@@ -0,0 +1,148 @@
+package executor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"pg-test-task-2024/internal/config"
+	"pg-test-task-2024/internal/db"
+	"sort"
+	"syscall"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ArtifactsDirName is a name of the artifacts directory inside the workspace
+const ArtifactsDirName = "artifacts"
+
+// createArtifactsDir creates directory in the workspace, files from which are collected after exit
+func createArtifactsDir(workspace string, credential *syscall.Credential) (string, error) {
+	path := filepath.Join(workspace, ArtifactsDirName)
+	err := os.Mkdir(path, 0700)
+	if err != nil {
+		return "", err
+	}
+	if credential != nil {
+		err = os.Chown(path, int(credential.Uid), int(credential.Gid))
+		if err != nil {
+			return "", err
+		}
+	}
+	return path, nil
+}
+
+// artifactsStorage returns directory in which collected artifacts of the command are stored
+func artifactsStorage(id uuid.UUID) string {
+	return config.GetArtifactsDir() + id.String()
+}
+
+// ArtifactPath returns path of the stored artifact of the command
+func ArtifactPath(id uuid.UUID, name string) string {
+	return filepath.Join(artifactsStorage(id), name)
+}
+
+// collectArtifacts copies regular files from src to dst and returns their descriptions.
+// Directory and files are opened without following symlinks and, if credential is set,
+// only files owned by the script user are collected, so the script can't make the server
+// copy files which are not readable by the script. Files larger than the limit,
+// files which don't fit into the total limit and files with invalid names are skipped.
+func collectArtifacts(src, dst string, credential *syscall.Credential, logger *log.Logger) ([]db.ArtifactEntity, error) {
+	dirFd, err := syscall.Open(src, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open artifacts directory: %w", err)
+	}
+	dir := os.NewFile(uintptr(dirFd), src)
+	defer dir.Close()
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read artifacts directory: %w", err)
+	}
+	sort.Strings(names)
+
+	maxSize, remaining := int64(config.GetMaxArtifactSize()), int64(config.GetMaxArtifactsSize())
+	artifacts := make([]db.ArtifactEntity, 0)
+	for _, name := range names {
+		if !validArtifactName(name) {
+			logger.Printf("artifact %q is skipped: name should be valid UTF-8 without control characters", name)
+			continue
+		}
+		fd, err := syscall.Openat(dirFd, name, syscall.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			logger.Printf("artifact %q is skipped: %s", name, err)
+			continue
+		}
+		f := os.NewFile(uintptr(fd), name)
+		artifact, err := storeArtifact(f, dst, credential, min(maxSize, remaining))
+		_ = f.Close()
+		if err != nil {
+			logger.Printf("artifact %q is skipped: %s", name, err)
+			continue
+		}
+		remaining -= artifact.Size
+		artifacts = append(artifacts, artifact)
+	}
+	return artifacts, nil
+}
+
+// validArtifactName returns true if name may be stored in db and passed in url
+func validArtifactName(name string) bool {
+	if !utf8.ValidString(name) {
+		return false
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// storeArtifact copies the file to dst if its size does not exceed limit
+func storeArtifact(f *os.File, dst string, credential *syscall.Credential, limit int64) (db.ArtifactEntity, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return db.ArtifactEntity{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return db.ArtifactEntity{}, errors.New("not a regular file")
+	}
+	if credential != nil && info.Sys().(*syscall.Stat_t).Uid != credential.Uid {
+		return db.ArtifactEntity{}, errors.New("not owned by the script user")
+	}
+	if info.Size() > limit {
+		return db.ArtifactEntity{}, fmt.Errorf("size %d exceeds limit %d", info.Size(), limit)
+	}
+
+	err = os.MkdirAll(dst, 0700)
+	if err != nil {
+		return db.ArtifactEntity{}, err
+	}
+	path := filepath.Join(dst, info.Name())
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return db.ArtifactEntity{}, err
+	}
+	hash := sha256.New()
+	// the file may still grow, so limit is checked while copying
+	size, err := io.Copy(io.MultiWriter(out, hash), io.LimitReader(f, limit+1))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size > limit {
+		err = fmt.Errorf("size exceeds limit %d", limit)
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return db.ArtifactEntity{}, err
+	}
+	return db.ArtifactEntity{Name: info.Name(), Size: size, Sha256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
@@ -0,0 +1,85 @@
+package executor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+	"pg-test-task-2024/internal/db"
+	"reflect"
+	"testing"
+)
+
+func TestCollectArtifacts(t *testing.T) {
+	t.Setenv("EXECUTOR_MAX_ARTIFACT_SIZE", "4")
+	t.Setenv("EXECUTOR_MAX_ARTIFACTS_SIZE", "6")
+	src, dst := t.TempDir(), filepath.Join(t.TempDir(), "cmd")
+	secret := filepath.Join(t.TempDir(), "secret")
+	for name, data := range map[string]string{
+		"a":     "abc",
+		"b":     "too large",
+		"c":     "def",
+		"d":     "gh",
+		secret:  "secret",
+		"dir/e": "e",
+		"\xff":  "invalid name",
+		"a\nb":  "control character",
+	} {
+		path := name
+		if !filepath.IsAbs(name) {
+			path = filepath.Join(src, name)
+		}
+		err := os.MkdirAll(filepath.Dir(path), 0700)
+		if err == nil {
+			err = os.WriteFile(path, []byte(data), 0600)
+		}
+		if err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	err := os.Symlink(secret, filepath.Join(src, "link"))
+	if err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	artifacts, err := collectArtifacts(src, dst, nil, log.Default())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// b exceeds the limit of one artifact, d does not fit into the total limit,
+	// directories, symlinks and files with invalid names are not collected
+	hash := sha256.Sum256([]byte("abc"))
+	expectedA := db.ArtifactEntity{Name: "a", Size: 3, Sha256: hex.EncodeToString(hash[:])}
+	hash = sha256.Sum256([]byte("def"))
+	expectedC := db.ArtifactEntity{Name: "c", Size: 3, Sha256: hex.EncodeToString(hash[:])}
+	if !reflect.DeepEqual(artifacts, []db.ArtifactEntity{expectedA, expectedC}) {
+		t.Fatalf("got artifacts %v", artifacts)
+	}
+	entries, err := os.ReadDir(dst)
+	if err != nil {
+		t.Fatalf("failed to read storage: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Name() != "a" || entries[1].Name() != "c" {
+		t.Fatalf("got stored files %v, expected a and c", entries)
+	}
+}
+
+func TestCollectArtifacts_DoesNotFollowSymlinkedDir(t *testing.T) {
+	target := t.TempDir()
+	err := os.WriteFile(filepath.Join(target, "secret"), []byte("secret"), 0600)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	src := filepath.Join(t.TempDir(), "artifacts")
+	err = os.Symlink(target, src)
+	if err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	artifacts, err := collectArtifacts(src, t.TempDir(), nil, log.Default())
+	if err == nil || len(artifacts) != 0 {
+		t.Fatalf("got artifacts %v and error %v, expected error", artifacts, err)
+	}
+}
@@ -0,0 +1,121 @@
+package executor
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pg-test-task-2024/internal/db"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cpuPeriod is a period of cpu.max in microseconds
+const cpuPeriod = 100000
+
+// cgroup v2 of one command. The script is started directly in it,
+// so all its descendants are limited and accounted together.
+type cgroup struct {
+	path string
+	dir  *os.File
+}
+
+// createCgroup creates cgroup of the command in root and sets limits of it.
+// root should be a cgroup v2 directory delegated to the executor.
+func createCgroup(root string, name string, limits db.ResourceLimits) (*cgroup, error) {
+	// controllers may be already enabled or enabled only partially,
+	// so error is ignored and missing controllers are reported on writing limits
+	_ = os.WriteFile(filepath.Join(root, "cgroup.subtree_control"), []byte("+cpu +memory +pids"), 0644)
+
+	path := filepath.Join(root, name)
+	err := os.Mkdir(path, 0755)
+	if err != nil {
+		return nil, err
+	}
+	cg := &cgroup{path: path}
+
+	type setting struct {
+		file, value string
+		optional    bool
+	}
+	settings := make([]setting, 0)
+	if limits.Memory > 0 {
+		settings = append(settings,
+			setting{"memory.max", strconv.FormatInt(limits.Memory, 10), false},
+			// swap is not accounted in memory.max, the file does not exist if swap is disabled
+			setting{"memory.swap.max", "0", true})
+	}
+	if limits.CPU > 0 {
+		quota := max(int64(limits.CPU*cpuPeriod), 1000)
+		settings = append(settings, setting{"cpu.max", fmt.Sprintf("%d %d", quota, cpuPeriod), false})
+	}
+	if limits.Pids > 0 {
+		settings = append(settings, setting{"pids.max", strconv.FormatInt(limits.Pids, 10), false})
+	}
+	for _, s := range settings {
+		err = os.WriteFile(filepath.Join(path, s.file), []byte(s.value), 0644)
+		if err != nil && !s.optional {
+			_ = cg.remove()
+			return nil, fmt.Errorf("failed to set %s: %w", s.file, err)
+		}
+	}
+
+	cg.dir, err = os.Open(path)
+	if err != nil {
+		_ = cg.remove()
+		return nil, err
+	}
+	return cg, nil
+}
+
+// fd should be passed to SysProcAttr.CgroupFD to start the process in the cgroup
+func (c *cgroup) fd() int {
+	return int(c.dir.Fd())
+}
+
+// oomKilled returns true if some process of the cgroup was killed by OOM killer
+func (c *cgroup) oomKilled() (bool, error) {
+	data, err := os.ReadFile(filepath.Join(c.path, "memory.events"))
+	if err != nil {
+		return false, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || name != "oom_kill" {
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("invalid memory.events: %w", err)
+		}
+		return n > 0, nil
+	}
+	return false, nil
+}
+
+// remove kills processes left in the cgroup and removes it.
+// Removal is retried, because killed processes exit asynchronously.
+func (c *cgroup) remove() error {
+	if c.dir != nil {
+		_ = c.dir.Close()
+	}
+	// cgroup.kill is available since Linux 5.14
+	_ = os.WriteFile(filepath.Join(c.path, "cgroup.kill"), []byte("1"), 0644)
+	var err error
+	for i := 0; i < 50; i++ {
+		err = syscall.Rmdir(c.path)
+		if !errors.Is(err, syscall.EBUSY) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		return &os.PathError{Op: "rmdir", Path: c.path, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"pg-test-task-2024/internal/db"
+	"testing"
+)
+
+// readCgroupFile returns content of the file written by createCgroup in the regular directory
+func readCgroupFile(t *testing.T, cg *cgroup, name string) string {
+	data, err := os.ReadFile(filepath.Join(cg.path, name))
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", name, err)
+	}
+	return string(data)
+}
+
+func TestCreateCgroup_WritesLimits(t *testing.T) {
+	root := t.TempDir()
+	cg, err := createCgroup(root, "cmd", db.ResourceLimits{Memory: 1 << 20, CPU: 0.5, Pids: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer cg.dir.Close()
+
+	for name, expected := range map[string]string{
+		"memory.max":      "1048576",
+		"memory.swap.max": "0",
+		"cpu.max":         "50000 100000",
+		"pids.max":        "10",
+	} {
+		if got := readCgroupFile(t, cg, name); got != expected {
+			t.Fatalf("got %s %q, expected %q", name, got, expected)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(root, "cgroup.subtree_control"))
+	if err != nil || string(data) != "+cpu +memory +pids" {
+		t.Fatalf("controllers are not enabled in root: %q %v", data, err)
+	}
+}
+
+func TestCgroup_OOMKilled(t *testing.T) {
+	for _, tc := range []struct {
+		events   string
+		expected bool
+	}{
+		{"low 0\nhigh 0\nmax 3\noom 1\noom_kill 0\n", false},
+		{"low 0\nhigh 0\nmax 3\noom 1\noom_kill 2\noom_group_kill 0\n", true},
+	} {
+		cg := &cgroup{path: t.TempDir()}
+		err := os.WriteFile(filepath.Join(cg.path, "memory.events"), []byte(tc.events), 0644)
+		if err != nil {
+			t.Fatalf("failed to write memory.events: %v", err)
+		}
+		got, err := cg.oomKilled()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != tc.expected {
+			t.Fatalf("got %v for %q, expected %v", got, tc.events, tc.expected)
+		}
+	}
+}
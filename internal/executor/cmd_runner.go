@@ -8,10 +8,15 @@ import (
 	"github.com/jackc/pgx/v4"
 	"io"
 	"log"
+	"os"
 	"os/exec"
 	"pg-test-task-2024/internal/config"
 	"pg-test-task-2024/internal/db"
+	"sort"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 )
 
 func setCmdFailed(ctx context.Context, worker db.TransactionWorker, id uuid.UUID, description string) {
@@ -24,26 +29,217 @@ func setCmdFailed(ctx context.Context, worker db.TransactionWorker, id uuid.UUID
 	})
 }
 
+// readOutput reads reader until EOF and writes everything to the output
+// of the command. Returned error is suitable for the status description.
+func readOutput(writer *outputWriter, stream db.OutputStream, reader io.Reader, logger *log.Logger) error {
+	buffer := make([]byte, 1024)
+	for {
+		n, err := reader.Read(buffer)
+		if n != 0 {
+			writeErr := writer.Write(stream, buffer[:n])
+			if writeErr != nil {
+				return errors.New("failed to append command output")
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			logger.Printf("failed to read %s: %s", stream, err)
+			return fmt.Errorf("failed to read %s", stream)
+		}
+	}
+}
+
+// envList converts env to the list of "name=value" strings sorted by name
+func envList(env map[string]string) []string {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]string, 0, len(env))
+	for _, name := range names {
+		list = append(list, name+"="+env[name])
+	}
+	return list
+}
+
+// signalProcessGroup sends signal to the process and all its descendants,
+// which have not left the process group
+func signalProcessGroup(process *os.Process, sig syscall.Signal) error {
+	err := syscall.Kill(-process.Pid, sig)
+	if errors.Is(err, syscall.ESRCH) {
+		return os.ErrProcessDone
+	}
+	return err
+}
+
+// toResourceUsage converts rusage of the exited process. On Linux max RSS is in kilobytes.
+func toResourceUsage(rusage *syscall.Rusage) db.ResourceUsage {
+	return db.ResourceUsage{
+		UserCPU:                time.Duration(rusage.Utime.Nano()),
+		SystemCPU:              time.Duration(rusage.Stime.Nano()),
+		MaxRSS:                 rusage.Maxrss,
+		InBlocks:               rusage.Inblock,
+		OutBlocks:              rusage.Oublock,
+		VoluntaryCtxSwitches:   rusage.Nvcsw,
+		InvoluntaryCtxSwitches: rusage.Nivcsw,
+	}
+}
+
 type CmdRunner func(
 	ctx context.Context,
-	id uuid.UUID,
+	running *RunningCmd,
 	worker db.TransactionWorker)
 
-func defaultRunner(ctx context.Context, id uuid.UUID, worker db.TransactionWorker) {
+// selectRunner executes the command in the sandbox if it was requested on submission
+func selectRunner(ctx context.Context, running *RunningCmd, worker db.TransactionWorker) {
+	if running.Options.Sandbox {
+		sandboxRunner(ctx, running, worker)
+		return
+	}
+	defaultRunner(ctx, running, worker)
+}
+
+// defaultRunner executes the script in namespaces of the server
+func defaultRunner(ctx context.Context, running *RunningCmd, worker db.TransactionWorker) {
+	runCommand(ctx, running, worker, "defaultRunner", false)
+}
+
+// sandboxRunner executes the script in its own user, mount, PID and network namespaces.
+// Root is read-only, /tmp is private, the working directory is the only writable one.
+func sandboxRunner(ctx context.Context, running *RunningCmd, worker db.TransactionWorker) {
+	runCommand(ctx, running, worker, "sandboxRunner", true)
+}
+
+// cancelingCmdErrPrefix is a prefix of the error returned by exec.Cmd.Wait if Cancel failed
+const cancelingCmdErrPrefix = "exec: canceling Cmd"
+
+func runCommand(ctx context.Context, running *RunningCmd, worker db.TransactionWorker, name string, sandboxed bool) {
+	id := running.Id
 	defaultLogger := log.Default()
 	fname := config.GetCmdDir() + id.String()
 	logger := log.New(
 		defaultLogger.Writer(),
-		fmt.Sprintf("defaultRunner %s: ", fname),
+		fmt.Sprintf("%s %s: ", name, fname),
 		defaultLogger.Flags()|log.Lmsgprefix)
 
-	s, err := exec.LookPath("/bin/sh")
+	script, err := os.ReadFile(fname)
+	if err != nil {
+		logger.Printf("failed to read script: %s", err)
+		setCmdFailed(ctx, worker, id, "failed to read script")
+		return
+	}
+	// allowlist may be changed after the command was submitted, so shebang is checked again
+	interpreter, err := ParseShebang(string(script), config.GetInterpreters())
+	if err != nil {
+		logger.Printf("failed to parse shebang: %s", err)
+		setCmdFailed(ctx, worker, id, err.Error())
+		return
+	}
+	s, err := exec.LookPath(interpreter.Path)
+	if err != nil {
+		logger.Printf("failed to look path of %s: %s", interpreter.Path, err)
+		setCmdFailed(ctx, worker, id, interpreter.Path+" not found")
+		return
+	}
+	opts := running.Options
+	credential, unique, releaseCredential := scriptCredential()
+	// released after the cgroup is removed, so no process of the script is left with the UID
+	defer releaseCredential()
+	if !unique {
+		logger.Printf("all script UIDs are used, UID %d is shared with another script", credential.Uid)
+	}
+	workspace, err := createWorkspace(id, credential)
+	if err != nil {
+		logger.Printf("failed to create workspace: %s", err)
+		setCmdFailed(ctx, worker, id, "failed to create workspace")
+		return
+	}
+	defer releaseWorkspace(workspace, logger)
+	err = placeInputFiles(InputFilesDir(id), workspace, credential)
+	if err != nil {
+		logger.Printf("failed to place input files: %s", err)
+		setCmdFailed(ctx, worker, id, "failed to place input files")
+		return
+	}
+	artifactsDir, err := createArtifactsDir(workspace, credential)
+	if err != nil {
+		logger.Printf("failed to create artifacts directory: %s", err)
+		setCmdFailed(ctx, worker, id, "failed to create artifacts directory")
+		return
+	}
+	err = worker(ctx, func(tx pgx.Tx) error {
+		err := db.SetCommandWorkspace(ctx, tx, id, workspace)
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
 	if err != nil {
-		logger.Printf("failed to look path of /bin/bash: %s", err)
-		setCmdFailed(ctx, worker, id, "/bin/bash not found")
+		logger.Printf("failed to save workspace: %s", err)
+		setCmdFailed(ctx, worker, id, "internal error")
 		return
 	}
-	cmd := exec.CommandContext(ctx, s, fname)
+	limits := resolveLimits(opts.Limits)
+	var cg *cgroup
+	if root := config.GetCgroupRoot(); root != "" {
+		cg, err = createCgroup(root, id.String(), limits)
+		if err != nil {
+			// limits are still applied with rlimits where possible
+			logger.Printf("failed to create cgroup: %s", err)
+			cg = nil
+		} else {
+			defer func() {
+				err := cg.remove()
+				if err != nil {
+					logger.Printf("failed to remove cgroup: %s", err)
+				}
+			}()
+		}
+	}
+	// UID is unique only if it is taken from the pool, the server UID is shared with the server
+	uniqueUID := credential != nil && unique
+	if cg == nil && limits.Pids > 0 && !uniqueUID {
+		logger.Printf("pids are not limited: no cgroup and script does not have its own UID")
+	}
+	shim := shimOptions{limits: rlimits(limits, cg != nil, uniqueUID)}
+	if sandboxed {
+		shim.sandbox = &sandboxMounts{workspace: workspace, readOnly: []string{fname}}
+	}
+	path, args := s, interpreter.Args(fname, opts.Args)
+	if shim.needed() {
+		path, args, err = shimCommand(shim, path, args)
+		if err != nil {
+			logger.Printf("failed to get shim command: %s", err)
+			setCmdFailed(ctx, worker, id, "failed to start shim")
+			return
+		}
+	}
+	cmdCtx, killCmd := context.WithCancel(ctx)
+	defer killCmd()
+	cmd := exec.CommandContext(cmdCtx, path, args...)
+	cmd.Env = scriptEnv(os.Environ(), running, artifactsDir)
+	cmd.Dir = workspace
+	// script gets its own process group, so processes started by the script
+	// are killed together with it on cancel, timeout or shutdown
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if sandboxed {
+		setSandboxAttr(cmd.SysProcAttr, credential)
+	} else {
+		// nil credential means that the script is executed as the server user
+		cmd.SysProcAttr.Credential = credential
+	}
+	if cg != nil {
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = cg.fd()
+	}
+	exited := make(chan struct{})
+	cmd.Cancel = func() error {
+		return running.terminate(context.Cause(cmdCtx), cmd.Process, exited)
+	}
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -51,6 +247,23 @@ func defaultRunner(ctx context.Context, id uuid.UUID, worker db.TransactionWorke
 		setCmdFailed(ctx, worker, id, "failed to connect to script stdout")
 		return
 	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		logger.Printf("failed to get stderr pipe: %s", err)
+		setCmdFailed(ctx, worker, id, "failed to connect to script stderr")
+		return
+	}
+	var stdin io.WriteCloser
+	if opts.Interactive {
+		stdin, err = cmd.StdinPipe()
+		if err != nil {
+			logger.Printf("failed to get stdin pipe: %s", err)
+			setCmdFailed(ctx, worker, id, "failed to connect to script stdin")
+			return
+		}
+	} else if opts.Stdin != nil {
+		cmd.Stdin = strings.NewReader(*opts.Stdin)
+	}
 	err = cmd.Start()
 	if err != nil {
 		logger.Printf("failed to start command: %T %s", err, err)
@@ -58,64 +271,135 @@ func defaultRunner(ctx context.Context, id uuid.UUID, worker db.TransactionWorke
 		return
 	}
 	logger.Printf("command started")
-
-	buffer := make([]byte, 1024)
-	for {
-		n, err := stdout.Read(buffer)
-		if err != nil {
-			if err != io.EOF {
-				logger.Printf("failed to read stdout: %s", err)
-				setCmdFailed(ctx, worker, id, "failed to read stdout")
-				return
-			}
-		}
-		if n != 0 {
-			str := string(buffer[:n])
-			err = worker(ctx, func(tx pgx.Tx) error {
-				err := db.AppendCommandOutput(ctx, tx, id, str)
+	// output read after cancellation and exit status of the script are still saved
+	dbCtx := context.WithoutCancel(ctx)
+	if stdin != nil {
+		defer running.SetStdin(nil)
+		if opts.Stdin == nil {
+			running.SetStdin(stdin)
+		} else {
+			// payload is written before any client may attach,
+			// writing may block until the script reads it
+			go func() {
+				_, err := io.WriteString(stdin, *opts.Stdin)
 				if err != nil {
-					return err
+					logger.Printf("failed to write stdin payload: %s", err)
+					return
 				}
-				return tx.Commit(ctx)
-			})
-			if err != nil {
-				logger.Printf("failed to append command output: %s", err)
-				setCmdFailed(ctx, worker, id, "failed to append command output")
-				return
-			}
-			logger.Printf("append %v bytes to command output", n)
-		}
-		if n == 0 || err == io.EOF {
-			break
+				running.SetStdin(stdin)
+			}()
 		}
 	}
 
+	limiter := newOutputLimiter(resolveOutputLimit(opts.OutputLimit), opts.OutputPolicy)
+	writer := newOutputWriter(dbCtx, worker, running, logger,
+		config.GetOutputFlushSize(), config.GetOutputFlushInterval(), limiter)
+
+	// if reading of one stream fails, the process is killed,
+	// so reading of the other stream also stops
+	readErrs := make(chan error, 2)
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	for stream, reader := range map[db.OutputStream]io.Reader{db.Stdout: stdout, db.Stderr: stderr} {
+		go func() {
+			defer wg.Done()
+			err := readOutput(writer, stream, reader, logger)
+			if err != nil {
+				readErrs <- err
+				killCmd()
+			}
+		}()
+	}
+	wg.Wait()
+	writeErr := writer.Close()
 	err = cmd.Wait()
+	close(exited)
+	close(readErrs)
+	if err, ok := <-readErrs; ok {
+		setCmdFailed(dbCtx, worker, id, err.Error())
+		return
+	}
+	if writeErr != nil {
+		setCmdFailed(dbCtx, worker, id, "failed to append command output")
+		return
+	}
+
+	// after graceful cancel Wait returns the cause of cancellation even if the script
+	// exited, so the exit status is saved unless the script was not waited or Cancel failed
+	processState := cmd.ProcessState
 	if err != nil {
 		var exitErr *exec.ExitError
 		if !errors.As(err, &exitErr) {
 			logger.Printf("cmd.Wait err: %s", err)
-			setCmdFailed(ctx, worker, id, "internal error")
+		}
+		if processState == nil || strings.HasPrefix(err.Error(), cancelingCmdErrPrefix) {
+			setCmdFailed(dbCtx, worker, id, "internal error")
 			return
 		}
 	}
 
-	processState := cmd.ProcessState
 	status := processState.Sys().(syscall.WaitStatus)
 	if status.Exited() {
 		logger.Printf("finished with exit status: %v", status.ExitStatus())
 	} else {
 		logger.Printf("ended with signal: %v", status.Signal())
 	}
-	err = worker(ctx, func(tx pgx.Tx) error {
-		err := db.SetCommandFinished(ctx, tx, id, status)
+	rusage, hasUsage := processState.SysUsage().(*syscall.Rusage)
+	oomKilled := false
+	if cg != nil && limits.Memory > 0 {
+		oomKilled, err = cg.oomKilled()
+		if err != nil {
+			logger.Printf("failed to read memory events: %s", err)
+		}
+	}
+	artifacts, err := collectArtifacts(artifactsDir, artifactsStorage(id), credential, logger)
+	if err != nil {
+		// exit status is saved anyway
+		logger.Printf("failed to collect artifacts: %s", err)
+	}
+	err = worker(dbCtx, func(tx pgx.Tx) error {
+		err := db.SetCommandFinished(dbCtx, tx, id, status)
 		if err != nil {
 			return err
 		}
-		return tx.Commit(ctx)
+		if hasUsage {
+			err = db.SetCommandResourceUsage(dbCtx, tx, id, toResourceUsage(rusage))
+			if err != nil {
+				return err
+			}
+		}
+		if oomKilled {
+			logger.Printf("killed by OOM killer")
+			err = db.SetCommandOutOfMemory(dbCtx, tx, id,
+				fmt.Sprintf("killed by OOM killer, memory limit is %d bytes", limits.Memory))
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Commit(dbCtx)
 	})
 	if err != nil {
 		logger.Printf("failed to set command finished: %s", err)
-		setCmdFailed(ctx, worker, id, "internal error")
+		setCmdFailed(dbCtx, worker, id, "internal error")
+		return
+	}
+
+	// artifacts are saved separately, so failure to save them does not change the outcome of the command
+	if len(artifacts) == 0 {
+		return
+	}
+	err = worker(dbCtx, func(tx pgx.Tx) error {
+		err := db.AddCommandArtifacts(dbCtx, tx, id, artifacts)
+		if err != nil {
+			return err
+		}
+		return tx.Commit(dbCtx)
+	})
+	if err != nil {
+		logger.Printf("failed to save artifacts: %s", err)
+		err = os.RemoveAll(artifactsStorage(id))
+		if err != nil {
+			logger.Printf("failed to remove stored artifacts: %s", err)
+		}
 	}
 }
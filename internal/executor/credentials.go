@@ -0,0 +1,63 @@
+package executor
+
+import (
+	"pg-test-task-2024/internal/config"
+	"sync"
+	"syscall"
+)
+
+// uidPool tracks UIDs of running scripts, so each script gets its own UID
+// and may not signal or trace other scripts while the pool is not exhausted
+type uidPool struct {
+	mtx  sync.Mutex
+	used map[uint32]int
+	next int
+}
+
+func newUIDPool() *uidPool {
+	return &uidPool{used: make(map[uint32]int)}
+}
+
+// acquire returns free UID from uids. If all of them are used, UID is shared
+// with another script. UID should be released after the script exits.
+func (p *uidPool) acquire(uids []uint32) (uint32, bool) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for i := range uids {
+		uid := uids[(p.next+i)%len(uids)]
+		if p.used[uid] == 0 {
+			p.next = (p.next + i + 1) % len(uids)
+			p.used[uid]++
+			return uid, true
+		}
+	}
+	uid := uids[p.next%len(uids)]
+	p.next = (p.next + 1) % len(uids)
+	p.used[uid]++
+	return uid, false
+}
+
+func (p *uidPool) release(uid uint32) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.used[uid]--
+	if p.used[uid] <= 0 {
+		delete(p.used, uid)
+	}
+}
+
+// scriptUIDs is shared by all runners
+var scriptUIDs = newUIDPool()
+
+// scriptCredential returns credential of the script and function to release it.
+// Credential is nil if scripts are executed as the server user.
+func scriptCredential() (*syscall.Credential, bool, func()) {
+	users := config.GetScriptUsers()
+	if users == nil {
+		return nil, true, func() {}
+	}
+	uid, unique := scriptUIDs.acquire(users.UIDs)
+	// empty Groups drops supplementary groups of the server
+	credential := &syscall.Credential{Uid: uid, Gid: users.GID, Groups: []uint32{}}
+	return credential, unique, func() { scriptUIDs.release(uid) }
+}
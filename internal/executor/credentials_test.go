@@ -0,0 +1,26 @@
+package executor
+
+import "testing"
+
+func TestUIDPool(t *testing.T) {
+	pool := newUIDPool()
+	uids := []uint32{1000, 1001}
+
+	first, unique := pool.acquire(uids)
+	if first != 1000 || !unique {
+		t.Fatalf("got %d %v, expected 1000 true", first, unique)
+	}
+	second, unique := pool.acquire(uids)
+	if second != 1001 || !unique {
+		t.Fatalf("got %d %v, expected 1001 true", second, unique)
+	}
+	if _, unique = pool.acquire(uids); unique {
+		t.Fatalf("UID should be shared when the pool is exhausted")
+	}
+
+	pool.release(second)
+	got, unique := pool.acquire(uids)
+	if got != second || !unique {
+		t.Fatalf("got %d %v, expected released UID %d", got, unique, second)
+	}
+}
@@ -0,0 +1,53 @@
+package executor
+
+import (
+	"pg-test-task-2024/internal/config"
+	"strings"
+)
+
+// metadata of the command passed to the script
+const (
+	cmdIdEnv     = "EXECUTOR_CMD_ID"
+	requestIdEnv = "EXECUTOR_REQUEST_ID"
+	artifactsEnv = "EXECUTOR_ARTIFACTS"
+)
+
+// serverEnvPrefix is a prefix of the server configuration, such variables are never passed to scripts
+const serverEnvPrefix = "EXECUTOR_"
+
+// allowedEnv returns true if variable of the server may be passed to the script
+func allowedEnv(name string, allowlist []string) bool {
+	if strings.HasPrefix(name, serverEnvPrefix) {
+		return false
+	}
+	for _, pattern := range allowlist {
+		prefix, isPrefix := strings.CutSuffix(pattern, "*")
+		if (isPrefix && strings.HasPrefix(name, prefix)) || name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// scriptEnv builds environment of the script from allowlisted variables of the server,
+// variables requested on submission and metadata of the command, which take precedence.
+// artifacts is a directory for files which should be collected after exit.
+func scriptEnv(serverEnv []string, running *RunningCmd, artifacts string) []string {
+	env := make(map[string]string)
+	allowlist := config.GetEnvAllowlist()
+	for _, variable := range serverEnv {
+		name, value, ok := strings.Cut(variable, "=")
+		if ok && allowedEnv(name, allowlist) {
+			env[name] = value
+		}
+	}
+	for name, value := range running.Options.Env {
+		env[name] = value
+	}
+	env[cmdIdEnv] = running.Id.String()
+	env[artifactsEnv] = artifacts
+	if running.Options.RequestId != "" {
+		env[requestIdEnv] = running.Options.RequestId
+	}
+	return envList(env)
+}
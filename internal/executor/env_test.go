@@ -0,0 +1,36 @@
+package executor
+
+import (
+	"github.com/google/uuid"
+	"pg-test-task-2024/internal/db"
+	"reflect"
+	"testing"
+)
+
+func TestScriptEnv(t *testing.T) {
+	t.Setenv("EXECUTOR_ENV_ALLOWLIST", "PATH,LC_*,EXECUTOR_DB_CONN_STR")
+	id := uuid.New()
+	running := &RunningCmd{Id: id, Options: db.CommandOptions{
+		Env:       map[string]string{"GREETING": "hello", "PATH": "/opt/bin", "EXECUTOR_CMD_ID": "fake"},
+		RequestId: "req-1",
+	}}
+
+	got := scriptEnv([]string{
+		"PATH=/usr/bin",
+		"LC_ALL=C",
+		"HOME=/root",
+		"EXECUTOR_DB_CONN_STR=postgres://user:secret@db:5432/executor_db",
+	}, running, "/tmp/workspaces/artifacts")
+
+	expected := []string{
+		"EXECUTOR_ARTIFACTS=/tmp/workspaces/artifacts",
+		"EXECUTOR_CMD_ID=" + id.String(),
+		"EXECUTOR_REQUEST_ID=req-1",
+		"GREETING=hello",
+		"LC_ALL=C",
+		"PATH=/opt/bin",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got %v, expected %v", got, expected)
+	}
+}
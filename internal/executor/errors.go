@@ -3,5 +3,16 @@ package executor
 import "errors"
 
 var (
-	ErrNotFound = errors.New("command not found")
+	ErrNotFound        = errors.New("command not found")
+	ErrNotInteractive  = errors.New("stdin of the command is not available")
+	ErrAlreadyAttached = errors.New("another client is attached to stdin of the command")
+	ErrCanceled        = errors.New("command canceled")
+	ErrTimeout         = errors.New("command timeout exceeded")
+	ErrAlreadyCanceled = errors.New("command is already being canceled")
+	ErrUnknownSignal   = errors.New("signal is not allowed")
+
+	ErrOutputLimitExceeded = errors.New("output limit exceeded")
+
+	ErrNoShebang          = errors.New("script should start with shebang line, e.g. #!/bin/bash")
+	ErrUnknownInterpreter = errors.New("interpreter is not allowed")
 )
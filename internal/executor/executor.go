@@ -2,26 +2,40 @@ package executor
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v4"
+	"io"
 	"log"
-	"os"
 	"pg-test-task-2024/internal/config"
 	"pg-test-task-2024/internal/db"
 	"sync"
+	"syscall"
+	"time"
 )
 
 // Submitter should send data to specified chan
 type Submitter func(uuid2 uuid.UUID)
 
+// SubmitterProvider returns Submitter which notifies the executor about new command.
+// Commands are taken from the queue in db, so notification is skipped
+// if the executor has not received the previous one yet.
 func SubmitterProvider(submitChan chan<- uuid.UUID) Submitter {
 	return func(id uuid.UUID) {
-		submitChan <- id
+		select {
+		case submitChan <- id:
+		default:
+		}
 	}
 }
 
+// pollInterval is how often the executor checks the queue without notifications,
+// e.g. if dispatching failed because db was not available
+const pollInterval = 5 * time.Second
+
 type Executor struct {
-	// toExecChan is a chan to which the cmd receiver sends command ids
+	// toExecChan is a chan to which the cmd receiver sends ids of queued commands
 	toExecChan <-chan uuid.UUID
 
 	worker db.TransactionWorker
@@ -32,17 +46,33 @@ type Executor struct {
 	// execute file with exec package
 	runner CmdRunner
 
-	// mtx to protect runningCommands
+	// hub delivers output of running commands to subscribers
+	hub *OutputHub
+
+	// maxRunning is max number of commands running at the same time
+	maxRunning int
+
+	// slotFreed is notified when some command is done
+	slotFreed chan struct{}
+
+	// queueMtx is held while the command is moved from the queue to runningCommands
+	// or removed from the queue, so the command being started can't be missed on cancel
+	queueMtx sync.Mutex
+
+	// mtx to protect runningCommands, it is not held during transactions
 	mtx sync.Mutex
 
-	// runningCommands contains a cancel function for each running command
-	runningCommands map[uuid.UUID]context.CancelFunc
+	// runningCommands contains each running command
+	runningCommands map[uuid.UUID]*RunningCmd
+
+	// wg is used to wait for runners to return
+	wg sync.WaitGroup
 }
 
 func New(toExecChan <-chan uuid.UUID, worker db.TransactionWorker, customRunner CmdRunner) *Executor {
 	defaultLogger := log.Default()
 	if customRunner == nil {
-		customRunner = defaultRunner
+		customRunner = selectRunner
 	}
 
 	return &Executor{
@@ -53,72 +83,291 @@ func New(toExecChan <-chan uuid.UUID, worker db.TransactionWorker, customRunner
 			"executor: ",
 			defaultLogger.Flags()|log.Lmsgprefix),
 		runner:          customRunner,
+		hub:             NewOutputHub(),
+		maxRunning:      config.GetMaxRunningCmds(),
+		slotFreed:       make(chan struct{}, 1),
+		queueMtx:        sync.Mutex{},
 		mtx:             sync.Mutex{},
-		runningCommands: make(map[uuid.UUID]context.CancelFunc),
+		runningCommands: make(map[uuid.UUID]*RunningCmd),
 	}
 }
 
-// Start starts separate goroutine
+// Start starts separate goroutine, which takes commands from the queue in db
+// when notified by Submitter or when some command is done.
+// Another goroutine removes expired workspaces of the commands.
 func (e *Executor) Start(ctx context.Context) {
+	e.wg.Add(1)
 	go func() {
+		defer e.wg.Done()
+		ticker := time.NewTicker(workspaceCleanupInterval)
+		defer ticker.Stop()
 		for {
+			e.removeExpiredWorkspaces(time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			// commands may be queued before start, so the queue is checked at once
+			e.dispatch(ctx)
 			select {
 			case <-ctx.Done():
 				e.logger.Printf("stopping, because context done: %s", ctx.Err())
 				return
-			case id, ok := <-e.toExecChan:
+			case _, ok := <-e.toExecChan:
 				if !ok {
 					e.logger.Printf("stopping, because chan closed")
 					return
 				}
-				fname := config.GetCmdDir() + id.String()
-				e.logger.Printf("request to exec: %s", fname)
-
-				runnerCtx, runnerCancel := context.WithCancel(ctx)
-				stop := context.AfterFunc(runnerCtx, func() {
-					err := e.worker(ctx, func(tx pgx.Tx) error {
-						err := db.SetCommandFailed(ctx, tx, id, "canceled")
-						if err != nil {
-							return err
-						}
-						return tx.Commit(ctx)
-					})
-					if err != nil {
-						e.logger.Printf("failed to set command %s canceled: %s", id, err)
-					} else {
-						e.logger.Printf("set command %s canceled", id)
-					}
-				})
-
-				e.mtx.Lock()
-				e.runningCommands[id] = runnerCancel
-				e.mtx.Unlock()
-				go func() {
-					defer os.Remove(fname)
-					defer runnerCancel()
-
-					// run the command
-					e.runner(runnerCtx, id, e.worker)
-
-					stop()
-
-					e.mtx.Lock()
-					delete(e.runningCommands, id)
-					e.mtx.Unlock()
-				}()
+			case <-e.slotFreed:
+			case <-ticker.C:
 			}
 		}
 	}()
 }
 
-func (e *Executor) CancelCmd(id uuid.UUID) error {
+// dispatch starts queued commands in order of submission while there are free slots
+func (e *Executor) dispatch(ctx context.Context) {
+	for ctx.Err() == nil {
+		running, runnerCtx, err := e.dequeue(ctx)
+		if err != nil {
+			if !errors.Is(err, db.ErrEntityNotFound) {
+				e.logger.Printf("failed to take command from queue: %s", err)
+			}
+			return
+		}
+		if running == nil {
+			return
+		}
+
+		fname := config.GetCmdDir() + running.Id.String()
+		e.logger.Printf("request to exec: %s", fname)
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			defer RemoveCmdFiles(running.Id)
+			defer running.cancel(nil)
+
+			e.run(ctx, runnerCtx, running)
+
+			e.mtx.Lock()
+			delete(e.runningCommands, running.Id)
+			e.mtx.Unlock()
+
+			running.Publish(CmdEvent{Done: true})
+			select {
+			case e.slotFreed <- struct{}{}:
+			default:
+			}
+		}()
+	}
+}
+
+// dequeue takes the next command from the queue and registers it as running.
+// Returned context should be passed to the runner. Returns nil if there are no free slots.
+func (e *Executor) dequeue(ctx context.Context) (*RunningCmd, context.Context, error) {
+	e.queueMtx.Lock()
+	defer e.queueMtx.Unlock()
+	// commands are added to runningCommands only here, so the number
+	// of running commands may only decrease until the command is added
+	e.mtx.Lock()
+	full := len(e.runningCommands) >= e.maxRunning
+	e.mtx.Unlock()
+	if full {
+		return nil, nil, nil
+	}
+
+	var id uuid.UUID
+	err := e.worker(ctx, func(tx pgx.Tx) error {
+		var err error
+		id, err = db.DequeueCommand(ctx, tx)
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	runnerCtx, runnerCancel := context.WithCancelCause(ctx)
+	running := &RunningCmd{
+		Id:     id,
+		cancel: runnerCancel,
+		hub:    e.hub,
+	}
+	e.mtx.Lock()
+	e.runningCommands[id] = running
+	e.mtx.Unlock()
+	return running, runnerCtx, nil
+}
+
+// runningCmd returns the running command with id
+func (e *Executor) runningCmd(id uuid.UUID) (*RunningCmd, bool) {
 	e.mtx.Lock()
 	defer e.mtx.Unlock()
-	cancel, ok := e.runningCommands[id]
+	running, ok := e.runningCommands[id]
+	return running, ok
+}
+
+// run loads options of the command and calls runner. If runnerCtx is done
+// before runner returns, the command is marked as canceled or timed out
+// and only then the context of the runner is canceled.
+func (e *Executor) run(ctx context.Context, runnerCtx context.Context, running *RunningCmd) {
+	id := running.Id
+	err := e.worker(ctx, func(tx pgx.Tx) error {
+		var err error
+		running.Options, err = db.GetCommandOptions(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		e.logger.Printf("failed to get options of command %s: %s", id, err)
+		setCmdFailed(ctx, e.worker, id, "internal error")
+		return
+	}
+
+	if running.Options.Timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		runnerCtx, cancelTimeout = context.WithTimeoutCause(runnerCtx, running.Options.Timeout, ErrTimeout)
+		defer cancelTimeout()
+	}
+
+	// status can't be changed after the command is finished, so the script is killed only after
+	// the command is marked canceled, otherwise the killed script could be saved as finished
+	cmdCtx, cancelCmd := context.WithCancelCause(context.WithoutCancel(runnerCtx))
+	defer cancelCmd(nil)
+	markedCanceled := make(chan struct{})
+	stop := context.AfterFunc(runnerCtx, func() {
+		defer close(markedCanceled)
+		cause := context.Cause(runnerCtx)
+		e.markCanceled(ctx, running, cause)
+		cancelCmd(cause)
+	})
+
+	// run the command
+	e.runner(cmdCtx, running, e.worker)
+
+	if !stop() {
+		// wait until command is marked as canceled,
+		// so subscribers will see the final status
+		<-markedCanceled
+	}
+
+	if running.Escalated() {
+		err = e.worker(ctx, func(tx pgx.Tx) error {
+			err := db.SetCommandCancelEscalated(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			return tx.Commit(ctx)
+		})
+		if err != nil {
+			e.logger.Printf("failed to set command %s cancel escalated: %s", id, err)
+		}
+	}
+}
+
+// markCanceled sets status of the command depending on the cause of cancellation
+func (e *Executor) markCanceled(ctx context.Context, running *RunningCmd, cause error) {
+	id := running.Id
+	err := e.worker(ctx, func(tx pgx.Tx) error {
+		var err error
+		if errors.Is(cause, ErrTimeout) {
+			err = db.SetCommandTimedOut(ctx, tx, id,
+				fmt.Sprintf("timeout %s exceeded", running.Options.Timeout))
+		} else if errors.Is(cause, ErrOutputLimitExceeded) {
+			err = db.SetCommandFailed(ctx, tx, id, "output limit exceeded")
+		} else if sig := running.CancelSignal(); sig != 0 {
+			err = db.SetCommandCanceled(ctx, tx, id, "canceled", int(sig))
+		} else {
+			err = db.SetCommandFailed(ctx, tx, id, "canceled")
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		e.logger.Printf("failed to set command %s canceled: %s", id, err)
+	} else {
+		e.logger.Printf("set command %s canceled: %s", id, cause)
+	}
+}
+
+// Wait blocks until all started runners return. After the context passed to Start
+// is done, runners kill their commands, so Wait may be used on shutdown.
+func (e *Executor) Wait() {
+	e.wg.Wait()
+}
+
+// CancelCmd sends requested signal to the running command and kills it
+// after the grace period. Only SIGKILL may be requested during the grace period.
+func (e *Executor) CancelCmd(id uuid.UUID, req CancelRequest) error {
+	running, ok := e.runningCmd(id)
 	if !ok {
-		return ErrNotFound
+		e.queueMtx.Lock()
+		// the command may be started while queueMtx was taken
+		running, ok = e.runningCmd(id)
+		if !ok {
+			err := e.cancelQueued(id)
+			e.queueMtx.Unlock()
+			return err
+		}
+		e.queueMtx.Unlock()
+	}
+	if req.Signal == 0 {
+		req.Signal = syscall.SIGKILL
+	}
+	e.logger.Printf("request to cancel command %s with %s, grace period %s",
+		id.String(), SignalName(req.Signal), req.GracePeriod)
+	return running.requestCancel(req)
+}
+
+// cancelQueued removes the command from the queue. Should be called with queueMtx locked.
+func (e *Executor) cancelQueued(id uuid.UUID) error {
+	ctx := context.Background()
+	err := e.worker(ctx, func(tx pgx.Tx) error {
+		err := db.SetQueuedCommandFailed(ctx, tx, id, "canceled before start")
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrEntityNotFound) {
+			return ErrNotFound
+		}
+		return err
 	}
-	e.logger.Printf("request to cancel command %s", id.String())
-	cancel()
+	e.logger.Printf("queued command %s canceled", id)
+	RemoveCmdFiles(id)
+	// subscribers of queued command are waiting for it to start
+	e.hub.Publish(id, CmdEvent{Done: true})
 	return nil
 }
+
+// Subscribe returns chan with events of the command and function to unsubscribe.
+// Subscription may be done before the command starts.
+func (e *Executor) Subscribe(id uuid.UUID) (<-chan CmdEvent, func()) {
+	return e.hub.Subscribe(id)
+}
+
+// AttachStdin returns stdin of the running interactive command and function to detach.
+// Only one client may be attached at a time.
+// Closing returned stdin sends EOF to the process.
+func (e *Executor) AttachStdin(id uuid.UUID) (io.WriteCloser, func(), error) {
+	running, ok := e.runningCmd(id)
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	return running.attachStdin()
+}
@@ -2,40 +2,71 @@ package executor
 
 import (
 	"context"
+	"errors"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"os"
 	"pg-test-task-2024/internal/config"
 	"pg-test-task-2024/internal/db"
 	"pg-test-task-2024/internal/db/dbtest"
+	"strings"
 	"sync"
+	"syscall"
 	"testing"
+	"time"
 )
 
+// insertCommands inserts n queued commands and returns their ids in order of submission.
+// Each command is inserted in separate transaction, so creation times differ.
+func insertCommands(t *testing.T, ctx context.Context, worker db.TransactionWorker, n int) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, n)
+	for i := 0; i < n; i++ {
+		err := worker(ctx, func(tx pgx.Tx) error {
+			id, err := db.InsertNewCommand(ctx, tx, "", db.CommandOptions{})
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+			return tx.Commit(ctx)
+		})
+		if err != nil {
+			t.Fatalf("failed to insert new commands: %v", err)
+		}
+	}
+	return ids
+}
+
 func TestExecutor_CallsRunner(t *testing.T) {
 	execChan := make(chan uuid.UUID)
 	defer close(execChan)
 
-	var stubTransactionWorker db.TransactionWorker
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to db: %v", err)
+	}
+	worker := db.TransactionWorkerProvider(pool)
 
 	wg := sync.WaitGroup{}
 	wg.Add(1)
 
 	runCount := 0
 	var gotId uuid.UUID
-	stubRunner := func(ctx context.Context, id uuid.UUID, worker db.TransactionWorker) {
+	stubRunner := func(ctx context.Context, running *RunningCmd, worker db.TransactionWorker) {
 		runCount += 1
-		gotId = id
+		gotId = running.Id
 		wg.Done()
 	}
 
-	exe := New(execChan, stubTransactionWorker, stubRunner)
-	ctx, cancel := context.WithCancel(context.Background())
+	exe := New(execChan, worker, stubRunner)
+	exeCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	exe.Start(ctx)
+	exe.Start(exeCtx)
 
-	expectedId := uuid.New()
+	expectedId := insertCommands(t, ctx, worker, 1)[0]
 
 	execChan <- expectedId
 
@@ -49,7 +80,75 @@ func TestExecutor_CallsRunner(t *testing.T) {
 	}
 }
 
+func TestExecutor_LimitsRunningCommands(t *testing.T) {
+	t.Setenv("EXECUTOR_MAX_RUNNING_CMDS", "2")
+	execChan := make(chan uuid.UUID, 1)
+	defer close(execChan)
+
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to db: %v", err)
+	}
+	worker := db.TransactionWorkerProvider(pool)
+
+	ids := insertCommands(t, ctx, worker, 3)
+
+	started := make(chan uuid.UUID, len(ids))
+	release := make(chan struct{})
+	stubRunner := func(ctx context.Context, running *RunningCmd, worker db.TransactionWorker) {
+		started <- running.Id
+		<-release
+	}
+
+	exe := New(execChan, worker, stubRunner)
+	exeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	exe.Start(exeCtx)
+
+	receiveStarted := func() uuid.UUID {
+		select {
+		case id := <-started:
+			return id
+		case <-time.After(10 * time.Second):
+			t.Fatalf("command is not started")
+			return uuid.Nil
+		}
+	}
+	for _, expectedId := range ids[:2] {
+		if id := receiveStarted(); id != expectedId {
+			t.Fatalf("got id %s, expected %s", id, expectedId)
+		}
+	}
+	select {
+	case id := <-started:
+		t.Fatalf("command %s started, while max number of commands are running", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	var entity db.CommandEntity
+	err = worker(ctx, func(tx pgx.Tx) error {
+		entity, err = db.GetSingleCommand(ctx, tx, ids[2])
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to get command: %v", err)
+	}
+	if entity.Status != db.Queued {
+		t.Fatalf("got status %v, expected %v", entity.Status, db.Queued)
+	}
+
+	// one of commands is done, so the last one is started
+	release <- struct{}{}
+	if id := receiveStarted(); id != ids[2] {
+		t.Fatalf("got id %s, expected %s", id, ids[2])
+	}
+	close(release)
+}
+
 func TestExecutor_CancelsRunners_WhenCanceled(t *testing.T) {
+	t.Setenv("EXECUTOR_MAX_RUNNING_CMDS", "10")
 	execChan := make(chan uuid.UUID)
 	defer close(execChan)
 
@@ -69,24 +168,9 @@ func TestExecutor_CancelsRunners_WhenCanceled(t *testing.T) {
 	wgAfterCtx := sync.WaitGroup{}
 	wgAfterCtx.Add(numRunners)
 
-	var ids []uuid.UUID
+	ids := insertCommands(t, ctx, worker, numRunners)
 
-	err = worker(ctx, func(tx pgx.Tx) error {
-		ids = make([]uuid.UUID, 0, numRunners)
-		for i := 0; i < numRunners; i++ {
-			id, err := db.InsertNewCommand(ctx, tx, "")
-			if err != nil {
-				return err
-			}
-			ids = append(ids, id)
-		}
-		return tx.Commit(ctx)
-	})
-	if err != nil {
-		t.Fatalf("failed to insert new commands: %v", err)
-	}
-
-	stubRunner := func(ctx context.Context, id uuid.UUID, worker db.TransactionWorker) {
+	stubRunner := func(ctx context.Context, running *RunningCmd, worker db.TransactionWorker) {
 		wgRunnerEntered.Done()
 		<-ctx.Done()
 		wgAfterCtx.Done()
@@ -112,3 +196,307 @@ func TestExecutor_CancelsRunners_WhenCanceled(t *testing.T) {
 
 	// TODO: check that commands marked as canceled in db
 }
+
+func TestExecutor_MarksCommandTimedOut(t *testing.T) {
+	execChan := make(chan uuid.UUID)
+	defer close(execChan)
+
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to db: %v", err)
+	}
+	worker := db.TransactionWorkerProvider(pool)
+
+	var id uuid.UUID
+	err = worker(ctx, func(tx pgx.Tx) error {
+		id, err = db.InsertNewCommand(ctx, tx, "", db.CommandOptions{Timeout: 100 * time.Millisecond})
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		t.Fatalf("failed to insert new command: %v", err)
+	}
+
+	var gotCause error
+	stubRunner := func(ctx context.Context, running *RunningCmd, worker db.TransactionWorker) {
+		<-ctx.Done()
+		gotCause = context.Cause(ctx)
+	}
+
+	exe := New(execChan, worker, stubRunner)
+	exeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	exe.Start(exeCtx)
+
+	events, unsubscribe := exe.Subscribe(id)
+	defer unsubscribe()
+	execChan <- id
+
+	select {
+	case <-events:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("command is not done after timeout")
+	}
+
+	if !errors.Is(gotCause, ErrTimeout) {
+		t.Fatalf("runner context canceled with %v, expected %v", gotCause, ErrTimeout)
+	}
+	var entity db.CommandEntity
+	err = worker(ctx, func(tx pgx.Tx) error {
+		entity, err = db.GetSingleCommand(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to get command: %v", err)
+	}
+	if entity.Status != db.Timeout {
+		t.Fatalf("got status %v, expected %v", entity.Status, db.Timeout)
+	}
+	if entity.StartedAt == nil || entity.FinishedAt == nil {
+		t.Fatalf("start and finish time should be set: got %v, %v", entity.StartedAt, entity.FinishedAt)
+	}
+	if entity.StartedAt.Before(entity.CreatedAt) || entity.FinishedAt.Before(*entity.StartedAt) {
+		t.Fatalf("timestamps are out of order: created %v, started %v, finished %v",
+			entity.CreatedAt, *entity.StartedAt, *entity.FinishedAt)
+	}
+}
+
+func TestExecutor_SavesExitCodeAfterGracefulCancel(t *testing.T) {
+	execChan := make(chan uuid.UUID)
+	defer close(execChan)
+
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to db: %v", err)
+	}
+	worker := db.TransactionWorkerProvider(pool)
+
+	script := "#!/bin/sh\ntrap 'echo cleanup; exit 0' TERM\necho ready\nsleep 100 &\nwait\n"
+	t.Setenv("EXECUTOR_CMD_DIR", t.TempDir())
+	t.Setenv("EXECUTOR_WORKSPACE_DIR", t.TempDir())
+	t.Setenv("EXECUTOR_ARTIFACTS_DIR", t.TempDir())
+	var id uuid.UUID
+	err = worker(ctx, func(tx pgx.Tx) error {
+		id, err = db.InsertNewCommand(ctx, tx, script, db.CommandOptions{})
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		t.Fatalf("failed to insert new command: %v", err)
+	}
+	err = os.WriteFile(config.GetCmdDir()+id.String(), []byte(script), 0644)
+	if err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+
+	exe := New(execChan, worker, defaultRunner)
+	exeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	exe.Start(exeCtx)
+
+	events, unsubscribe := exe.Subscribe(id)
+	defer unsubscribe()
+	execChan <- id
+
+	canceled := false
+	timeout := time.After(10 * time.Second)
+	for done := false; !done; {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatalf("subscription lagged")
+			}
+			done = event.Done
+			if !canceled && strings.Contains(event.Data, "ready") {
+				canceled = true
+				err = exe.CancelCmd(id, CancelRequest{Signal: syscall.SIGTERM, GracePeriod: 5 * time.Second})
+				if err != nil {
+					t.Fatalf("failed to cancel: %v", err)
+				}
+			}
+		case <-timeout:
+			t.Fatalf("command is not done after cancel")
+		}
+	}
+	exe.Wait()
+
+	var entity db.CommandEntity
+	err = worker(ctx, func(tx pgx.Tx) error {
+		entity, err = db.GetSingleCommand(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to get command: %v", err)
+	}
+	if entity.Status != db.Error || entity.StatusDesc != "canceled" {
+		t.Fatalf("got status %v %q, expected %v %q", entity.Status, entity.StatusDesc, db.Error, "canceled")
+	}
+	if entity.ExitCode == nil || *entity.ExitCode != 0 {
+		t.Fatalf("got exit code %v, expected 0", entity.ExitCode)
+	}
+	if entity.CancelSignal == nil || *entity.CancelSignal != int(syscall.SIGTERM) || entity.CancelEscalated {
+		t.Fatalf("got cancel signal %v, escalated %v", entity.CancelSignal, entity.CancelEscalated)
+	}
+	if entity.Usage == nil {
+		t.Fatalf("resource usage is not saved")
+	}
+}
+
+func TestExecutor_KeepsFinishedStatusOnCancelAfterFinish(t *testing.T) {
+	execChan := make(chan uuid.UUID)
+	defer close(execChan)
+
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to db: %v", err)
+	}
+	worker := db.TransactionWorkerProvider(pool)
+	id := insertCommands(t, ctx, worker, 1)[0]
+
+	// the script exits and is saved as finished, but cancel comes before the runner returns
+	finished := make(chan struct{})
+	stubRunner := func(ctx context.Context, running *RunningCmd, worker db.TransactionWorker) {
+		err := worker(ctx, func(tx pgx.Tx) error {
+			err := db.SetCommandFinished(ctx, tx, running.Id, syscall.WaitStatus(0))
+			if err != nil {
+				return err
+			}
+			return tx.Commit(ctx)
+		})
+		if err != nil {
+			t.Errorf("failed to set command finished: %v", err)
+		}
+		close(finished)
+		<-ctx.Done()
+	}
+
+	exe := New(execChan, worker, stubRunner)
+	exeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	exe.Start(exeCtx)
+
+	events, unsubscribe := exe.Subscribe(id)
+	defer unsubscribe()
+	execChan <- id
+	<-finished
+	err = exe.CancelCmd(id, CancelRequest{Signal: syscall.SIGTERM})
+	if err != nil {
+		t.Fatalf("failed to cancel: %v", err)
+	}
+
+	select {
+	case <-events:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("command is not done after cancel")
+	}
+
+	var entity db.CommandEntity
+	err = worker(ctx, func(tx pgx.Tx) error {
+		entity, err = db.GetSingleCommand(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to get command: %v", err)
+	}
+	if entity.Status != db.Finished || entity.ExitCode == nil || *entity.ExitCode != 0 {
+		t.Fatalf("got status %v with exit code %v, expected %v with 0", entity.Status, entity.ExitCode, db.Finished)
+	}
+	if entity.CancelSignal != nil {
+		t.Fatalf("got cancel signal %v of the finished command", *entity.CancelSignal)
+	}
+}
+
+type nopWriteCloser struct{}
+
+func (nopWriteCloser) Write(p []byte) (int, error) { return len(p), nil }
+func (nopWriteCloser) Close() error                { return nil }
+
+func TestExecutor_AttachStdin_OnlyOneWriter(t *testing.T) {
+	exe := New(nil, nil, nil)
+	id := uuid.New()
+	running := &RunningCmd{Id: id, hub: exe.hub}
+	exe.runningCommands[id] = running
+
+	_, _, err := exe.AttachStdin(id)
+	if !errors.Is(err, ErrNotInteractive) {
+		t.Fatalf("got error %v, expected %v", err, ErrNotInteractive)
+	}
+
+	running.SetStdin(nopWriteCloser{})
+	_, detach, err := exe.AttachStdin(id)
+	if err != nil {
+		t.Fatalf("unexpected error attaching stdin: %v", err)
+	}
+	_, _, err = exe.AttachStdin(id)
+	if !errors.Is(err, ErrAlreadyAttached) {
+		t.Fatalf("got error %v, expected %v", err, ErrAlreadyAttached)
+	}
+
+	detach()
+	stdin, _, err := exe.AttachStdin(id)
+	if err != nil {
+		t.Fatalf("unexpected error attaching stdin after detach: %v", err)
+	}
+
+	_ = stdin.Close()
+	_, _, err = exe.AttachStdin(id)
+	if !errors.Is(err, ErrNotInteractive) {
+		t.Fatalf("got error %v after closing stdin, expected %v", err, ErrNotInteractive)
+	}
+
+	_, _, err = exe.AttachStdin(uuid.New())
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got error %v, expected %v", err, ErrNotFound)
+	}
+}
+
+func TestExecutor_DoesNotBlockRunningCommandsDuringDequeue(t *testing.T) {
+	dequeueStarted := make(chan struct{})
+	finishDequeue := make(chan struct{})
+	// worker blocks like a slow transaction taking the command from the queue
+	blockingWorker := func(ctx context.Context, worker func(tx pgx.Tx) error) error {
+		close(dequeueStarted)
+		<-finishDequeue
+		return db.ErrEntityNotFound
+	}
+	exe := New(nil, blockingWorker, nil)
+	id := uuid.New()
+	running := &RunningCmd{Id: id, hub: exe.hub}
+	running.SetStdin(nopWriteCloser{})
+	exe.runningCommands[id] = running
+
+	dispatched := make(chan struct{})
+	go func() {
+		defer close(dispatched)
+		exe.dispatch(context.Background())
+	}()
+	<-dequeueStarted
+	defer func() {
+		close(finishDequeue)
+		<-dispatched
+	}()
+
+	attached := make(chan error, 1)
+	go func() {
+		_, _, err := exe.AttachStdin(id)
+		attached <- err
+	}()
+	select {
+	case err := <-attached:
+		if err != nil {
+			t.Fatalf("unexpected error attaching stdin: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("running command is not available while command is taken from the queue")
+	}
+}
@@ -0,0 +1,70 @@
+package executor
+
+import (
+	"pg-test-task-2024/internal/config"
+	"pg-test-task-2024/internal/db"
+	"syscall"
+)
+
+// rlimitNproc is RLIMIT_NPROC, it is not defined in syscall package
+const rlimitNproc = 0x6
+
+// rlimitResources maps names of rlimits passed to the shim to resources
+var rlimitResources = map[string]int{
+	"nofile": syscall.RLIMIT_NOFILE,
+	"fsize":  syscall.RLIMIT_FSIZE,
+	"nproc":  rlimitNproc,
+	"as":     syscall.RLIMIT_AS,
+}
+
+// rlimit is sized for the script, so it is applied only by the shim, which is replaced
+// with the script right after that. PID 1 of the sandbox does not apply rlimits to itself.
+type rlimit struct {
+	name  string
+	value int64
+}
+
+// lowerLimit returns the requested limit if it does not exceed the server limit. 0 means no limit.
+func lowerLimit[T int64 | float64](requested, server T) T {
+	if server == 0 || (requested > 0 && requested < server) {
+		return requested
+	}
+	return server
+}
+
+// resolveLimits returns limits of the script considering the server limits
+func resolveLimits(requested db.ResourceLimits) db.ResourceLimits {
+	return db.ResourceLimits{
+		Memory:   lowerLimit(requested.Memory, config.GetMemoryLimit()),
+		CPU:      lowerLimit(requested.CPU, config.GetCPULimit()),
+		Pids:     lowerLimit(requested.Pids, config.GetPidsLimit()),
+		NoFile:   lowerLimit(requested.NoFile, config.GetNoFileLimit()),
+		FileSize: lowerLimit(requested.FileSize, config.GetFileSizeLimit()),
+	}
+}
+
+// rlimits returns rlimits which should be applied to the script. If the cgroup is not used,
+// memory and pids are limited with rlimits of address space and number of processes of the user,
+// which are less precise. Number of processes is counted per UID, so it is limited only if the script
+// has its own UID, otherwise processes of the server or of other scripts would be counted too.
+// CPU is not limited without the cgroup.
+// Address space is the last one, so the shim sets it right before the exec.
+func rlimits(limits db.ResourceLimits, withCgroup, uniqueUID bool) []rlimit {
+	res := make([]rlimit, 0)
+	if limits.NoFile > 0 {
+		res = append(res, rlimit{"nofile", limits.NoFile})
+	}
+	if limits.FileSize > 0 {
+		res = append(res, rlimit{"fsize", limits.FileSize})
+	}
+	if withCgroup {
+		return res
+	}
+	if limits.Pids > 0 && uniqueUID {
+		res = append(res, rlimit{"nproc", limits.Pids})
+	}
+	if limits.Memory > 0 {
+		res = append(res, rlimit{"as", limits.Memory})
+	}
+	return res
+}
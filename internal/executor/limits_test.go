@@ -0,0 +1,43 @@
+package executor
+
+import (
+	"pg-test-task-2024/internal/db"
+	"reflect"
+	"testing"
+)
+
+func TestResolveLimits(t *testing.T) {
+	t.Setenv("EXECUTOR_MEMORY_LIMIT", "1000")
+	t.Setenv("EXECUTOR_CPU_LIMIT", "2")
+	t.Setenv("EXECUTOR_PIDS_LIMIT", "")
+	t.Setenv("EXECUTOR_NOFILE_LIMIT", "64")
+	t.Setenv("EXECUTOR_FSIZE_LIMIT", "")
+
+	got := resolveLimits(db.ResourceLimits{Memory: 500, CPU: 4, Pids: 10})
+	expected := db.ResourceLimits{Memory: 500, CPU: 2, Pids: 10, NoFile: 64}
+	if got != expected {
+		t.Fatalf("got %+v, expected %+v", got, expected)
+	}
+}
+
+func TestRlimits(t *testing.T) {
+	limits := db.ResourceLimits{Memory: 1 << 20, CPU: 1, Pids: 5, NoFile: 32}
+
+	got := rlimits(limits, true, true)
+	expected := []rlimit{{"nofile", 32}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("with cgroup got %v, expected %v", got, expected)
+	}
+
+	got = rlimits(limits, false, true)
+	expected = []rlimit{{"nofile", 32}, {"nproc", 5}, {"as", 1 << 20}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("without cgroup got %v, expected %v", got, expected)
+	}
+
+	got = rlimits(limits, false, false)
+	expected = []rlimit{{"nofile", 32}, {"as", 1 << 20}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("without cgroup and unique UID got %v, expected %v", got, expected)
+	}
+}
@@ -0,0 +1,12 @@
+package executor
+
+import (
+	"os"
+	"testing"
+)
+
+// TestMain lets the test binary work as the shim, which is started by defaultRunner to apply limits
+func TestMain(m *testing.M) {
+	RunShimIfRequested()
+	os.Exit(m.Run())
+}
@@ -0,0 +1,88 @@
+package executor
+
+import (
+	"github.com/google/uuid"
+	"pg-test-task-2024/internal/db"
+	"sync"
+)
+
+// subscriberBufferSize is a number of events which may be not received by
+// subscriber before it is considered lagged
+const subscriberBufferSize = 64
+
+// CmdEvent is sent to subscribers of the command when output is appended
+// or when the command is done. Event with Done set to true is the last one.
+type CmdEvent struct {
+	Stream db.OutputStream
+	Data   string
+	// End is the length of the stream in bytes after Data was appended
+	End  int64
+	Done bool
+}
+
+// OutputHub delivers events of running commands to subscribers.
+// Publishing never blocks: if subscriber does not keep up, its chan
+// is closed without the Done event, so subscriber may read
+// the output from db and subscribe again.
+type OutputHub struct {
+	mtx         sync.Mutex
+	subscribers map[uuid.UUID]map[chan CmdEvent]struct{}
+}
+
+func NewOutputHub() *OutputHub {
+	return &OutputHub{
+		subscribers: make(map[uuid.UUID]map[chan CmdEvent]struct{}),
+	}
+}
+
+// Subscribe returns chan with events of the command and function to unsubscribe.
+func (h *OutputHub) Subscribe(id uuid.UUID) (<-chan CmdEvent, func()) {
+	ch := make(chan CmdEvent, subscriberBufferSize)
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if _, ok := h.subscribers[id]; !ok {
+		h.subscribers[id] = make(map[chan CmdEvent]struct{})
+	}
+	h.subscribers[id][ch] = struct{}{}
+
+	return ch, func() {
+		h.mtx.Lock()
+		defer h.mtx.Unlock()
+		h.remove(id, ch)
+	}
+}
+
+// Publish sends event to all subscribers of the command. After the Done event
+// all subscribers are removed.
+func (h *OutputHub) Publish(id uuid.UUID, event CmdEvent) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	for ch := range h.subscribers[id] {
+		select {
+		case ch <- event:
+			if event.Done {
+				h.remove(id, ch)
+			}
+		default:
+			// subscriber lagged
+			h.remove(id, ch)
+		}
+	}
+}
+
+// remove should be called with mtx locked
+func (h *OutputHub) remove(id uuid.UUID, ch chan CmdEvent) {
+	chans, ok := h.subscribers[id]
+	if !ok {
+		return
+	}
+	if _, ok := chans[ch]; !ok {
+		return
+	}
+	delete(chans, ch)
+	close(ch)
+	if len(chans) == 0 {
+		delete(h.subscribers, id)
+	}
+}
@@ -0,0 +1,61 @@
+package executor
+
+import (
+	"github.com/google/uuid"
+	"pg-test-task-2024/internal/db"
+	"testing"
+)
+
+func TestOutputHub_PublishesToSubscribers(t *testing.T) {
+	hub := NewOutputHub()
+	id := uuid.New()
+
+	events, unsubscribe := hub.Subscribe(id)
+	defer unsubscribe()
+	otherEvents, otherUnsubscribe := hub.Subscribe(uuid.New())
+	defer otherUnsubscribe()
+
+	hub.Publish(id, CmdEvent{Stream: db.Stdout, Data: "hello", End: 5})
+	hub.Publish(id, CmdEvent{Done: true})
+
+	event := <-events
+	if event.Stream != db.Stdout || event.Data != "hello" || event.End != 5 {
+		t.Fatalf("got unexpected event %v", event)
+	}
+	event = <-events
+	if !event.Done {
+		t.Fatalf("expected done event, got %v", event)
+	}
+	if _, ok := <-events; ok {
+		t.Fatalf("expected chan to be closed after done event")
+	}
+
+	select {
+	case event := <-otherEvents:
+		t.Fatalf("subscriber of other command got event %v", event)
+	default:
+	}
+}
+
+func TestOutputHub_ClosesLaggedSubscriber(t *testing.T) {
+	hub := NewOutputHub()
+	id := uuid.New()
+
+	events, unsubscribe := hub.Subscribe(id)
+	defer unsubscribe()
+
+	for i := 0; i < subscriberBufferSize+1; i++ {
+		hub.Publish(id, CmdEvent{Stream: db.Stdout, Data: "a", End: int64(i + 1)})
+	}
+
+	received := 0
+	for event := range events {
+		if event.Done {
+			t.Fatalf("unexpected done event")
+		}
+		received += 1
+	}
+	if received != subscriberBufferSize {
+		t.Fatalf("received %d events, expected %d", received, subscriberBufferSize)
+	}
+}
@@ -0,0 +1,139 @@
+package executor
+
+import (
+	"fmt"
+	"pg-test-task-2024/internal/config"
+	"pg-test-task-2024/internal/db"
+)
+
+// resolveOutputLimit returns the limit of the saved output considering the server limit.
+// 0 means that the limit was not requested on submission.
+func resolveOutputLimit(limit int64) int64 {
+	maxSize := int64(config.GetMaxOutputSize())
+	if limit <= 0 || limit > maxSize {
+		return maxSize
+	}
+	return limit
+}
+
+// truncationMarker is saved between the head and the tail of the output
+func truncationMarker(dropped int64) []byte {
+	return []byte(fmt.Sprintf("\n... %d bytes truncated ...\n", dropped))
+}
+
+// outputLimiter decides which part of the output is saved according to the policy.
+// The head of the output is saved as it is read, the tail is kept in memory
+// and saved again on each flush replacing the previously saved tail, the rest is dropped.
+type outputLimiter struct {
+	policy    db.OutputPolicy
+	headLimit int64
+	tailLimit int64
+
+	headSize int64
+	tail     []db.OutputChunk
+	tailSize int64
+	// tailChanged is true if the tail changed since the last call of takeTail
+	tailChanged bool
+
+	// dropped is a number of bytes dropped since the last call of takeDropped
+	dropped      int64
+	totalDropped int64
+}
+
+func newOutputLimiter(limit int64, policy db.OutputPolicy) *outputLimiter {
+	l := &outputLimiter{policy: policy}
+	switch policy {
+	case db.KeepTail:
+		l.tailLimit = limit
+	case db.KeepHeadTail:
+		l.headLimit = limit / 2
+		l.tailLimit = limit - l.headLimit
+	default:
+		l.headLimit = limit
+	}
+	return l
+}
+
+// add returns part of data which should be saved at once.
+// exceeded is true when some output is dropped for the first time.
+func (l *outputLimiter) add(stream db.OutputStream, data []byte) (head []byte, exceeded bool) {
+	if free := l.headLimit - l.headSize; free > 0 {
+		n := min(free, int64(len(data)))
+		head, data = data[:n], data[n:]
+		l.headSize += n
+	}
+	if len(data) == 0 {
+		return head, false
+	}
+
+	wasTruncated := l.totalDropped > 0
+	last := len(l.tail) - 1
+	if last >= 0 && l.tail[last].Stream == stream {
+		l.tail[last].Data = append(l.tail[last].Data, data...)
+	} else {
+		l.tail = append(l.tail, db.OutputChunk{Stream: stream, Data: append([]byte(nil), data...)})
+	}
+	l.tailSize += int64(len(data))
+	l.tailChanged = l.tailLimit > 0
+
+	for l.tailSize > l.tailLimit {
+		extra := l.tailSize - l.tailLimit
+		first := &l.tail[0]
+		if int64(len(first.Data)) <= extra {
+			l.drop(int64(len(first.Data)))
+			l.tail = l.tail[1:]
+		} else {
+			first.Data = first.Data[extra:]
+			l.drop(extra)
+		}
+	}
+	return head, !wasTruncated && l.totalDropped > 0
+}
+
+func (l *outputLimiter) drop(n int64) {
+	l.tailSize -= n
+	l.dropped += n
+	l.totalDropped += n
+}
+
+// takeDropped returns number of bytes dropped since the previous call
+func (l *outputLimiter) takeDropped() int64 {
+	dropped := l.dropped
+	l.dropped = 0
+	return dropped
+}
+
+// tailChunks returns the tail of the output. If the middle of the output was dropped,
+// the tail starts with the marker.
+func (l *outputLimiter) tailChunks() []db.OutputChunk {
+	tail := make([]db.OutputChunk, 0, len(l.tail)+1)
+	if l.policy == db.KeepHeadTail && l.totalDropped > 0 && len(l.tail) > 0 {
+		tail = append(tail, db.OutputChunk{
+			Stream: l.tail[0].Stream,
+			Data:   truncationMarker(l.totalDropped),
+			Marker: true,
+			Tail:   true,
+		})
+	}
+	for _, chunk := range l.tail {
+		// data is not copied, because the limiter only appends to it or cuts its beginning
+		tail = append(tail, db.OutputChunk{Stream: chunk.Stream, Data: chunk.Data, Tail: true})
+	}
+	return tail
+}
+
+// takeTail returns the tail of the output and true if it changed since the previous call,
+// so the saved tail should be replaced
+func (l *outputLimiter) takeTail() ([]db.OutputChunk, bool) {
+	if !l.tailChanged {
+		return nil, false
+	}
+	l.tailChanged = false
+	return l.tailChunks(), true
+}
+
+// finish returns the tail of the output, which should be saved after the command exits
+func (l *outputLimiter) finish() []db.OutputChunk {
+	l.tailChanged = false
+	return l.tailChunks()
+}
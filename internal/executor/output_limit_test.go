@@ -0,0 +1,118 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"pg-test-task-2024/internal/db"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// limitOutput passes chunks through limiter and returns saved output of each stream
+func limitOutput(limiter *outputLimiter, chunks []db.OutputChunk) (map[db.OutputStream]string, int64) {
+	saved := make(map[db.OutputStream]string)
+	var dropped int64
+	for _, chunk := range chunks {
+		head, _ := limiter.add(chunk.Stream, chunk.Data)
+		saved[chunk.Stream] += string(head)
+		dropped += limiter.takeDropped()
+	}
+	for _, chunk := range limiter.finish() {
+		saved[chunk.Stream] += string(chunk.Data)
+	}
+	return saved, dropped + limiter.takeDropped()
+}
+
+func TestOutputLimiter_Policies(t *testing.T) {
+	chunks := []db.OutputChunk{
+		{Stream: db.Stdout, Data: []byte("0123")},
+		{Stream: db.Stderr, Data: []byte("ab")},
+		{Stream: db.Stdout, Data: []byte("456789")},
+	}
+	for _, tc := range []struct {
+		policy          db.OutputPolicy
+		limit           int64
+		expectedStdout  string
+		expectedStderr  string
+		expectedDropped int64
+	}{
+		{db.KeepHead, 100, "0123456789", "ab", 0},
+		{db.KeepTail, 100, "0123456789", "ab", 0},
+		{db.KeepHeadTail, 100, "0123456789", "ab", 0},
+		{db.KeepHead, 5, "0123", "a", 7},
+		{db.KillOnLimit, 5, "0123", "a", 7},
+		{db.KeepTail, 5, "56789", "", 7},
+		{db.KeepTail, 7, "456789", "b", 5},
+		{db.KeepHeadTail, 6, "012\n... 6 bytes truncated ...\n789", "", 6},
+		{db.KeepHeadTail, 10, "0123\n... 2 bytes truncated ...\n56789", "a", 2},
+	} {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			saved, dropped := limitOutput(newOutputLimiter(tc.limit, tc.policy), chunks)
+			if saved[db.Stdout] != tc.expectedStdout {
+				t.Fatalf("got stdout %q, expected %q", saved[db.Stdout], tc.expectedStdout)
+			}
+			if saved[db.Stderr] != tc.expectedStderr {
+				t.Fatalf("got stderr %q, expected %q", saved[db.Stderr], tc.expectedStderr)
+			}
+			if dropped != tc.expectedDropped {
+				t.Fatalf("got %d dropped bytes, expected %d", dropped, tc.expectedDropped)
+			}
+		})
+	}
+}
+
+func TestOutputLimiter_ReportsExceededOnce(t *testing.T) {
+	limiter := newOutputLimiter(2, db.KeepHead)
+	if _, exceeded := limiter.add(db.Stdout, []byte("ab")); exceeded {
+		t.Fatalf("limit is not exceeded yet")
+	}
+	if _, exceeded := limiter.add(db.Stdout, []byte("c")); !exceeded {
+		t.Fatalf("limit should be exceeded")
+	}
+	if _, exceeded := limiter.add(db.Stdout, []byte("d")); exceeded {
+		t.Fatalf("exceeding should be reported once")
+	}
+}
+
+func TestOutputLimiter_TakesTailOnlyWhenChanged(t *testing.T) {
+	limiter := newOutputLimiter(4, db.KeepHeadTail)
+	limiter.add(db.Stdout, []byte("01"))
+	if _, changed := limiter.takeTail(); changed {
+		t.Fatalf("tail is changed before head is full")
+	}
+
+	limiter.add(db.Stdout, []byte("2345"))
+	tail, changed := limiter.takeTail()
+	if !changed {
+		t.Fatalf("tail should be changed")
+	}
+	expected := "\n... 2 bytes truncated ...\n45"
+	if got := string(tail[0].Data) + string(tail[1].Data); got != expected {
+		t.Fatalf("got tail %q, expected %q", got, expected)
+	}
+	if _, changed = limiter.takeTail(); changed {
+		t.Fatalf("tail is not changed since previous call")
+	}
+}
+
+func TestOutputWriter_CancelsCommand_WhenLimitExceededWithKillPolicy(t *testing.T) {
+	var transactions atomic.Int64
+	limiter := newOutputLimiter(2, db.KillOnLimit)
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	writer, _ := newTestOutputWriter(t, countingTransactionWorker(&transactions), 1024, time.Hour)
+	writer.limiter = limiter
+	writer.running.cancel = cancel
+
+	_ = writer.Write(db.Stdout, []byte("ab"))
+	if ctx.Err() != nil {
+		t.Fatalf("command is canceled before limit exceeded")
+	}
+	_ = writer.Write(db.Stdout, []byte("c"))
+	if !errors.Is(context.Cause(ctx), ErrOutputLimitExceeded) {
+		t.Fatalf("got cause %v, expected %v", context.Cause(ctx), ErrOutputLimitExceeded)
+	}
+	_ = writer.Close()
+}
@@ -0,0 +1,217 @@
+package executor
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	"log"
+	"pg-test-task-2024/internal/db"
+	"sync"
+	"time"
+)
+
+// outputWriter buffers output of the command and saves it to db in one transaction
+// when buffered size exceeds maxSize or after interval since the last flush.
+// Subscribers of the command receive output after it is saved.
+// If limiter is set, only part of the output chosen by it is saved. The tail kept by the limiter
+// replaces the previously saved tail on each flush and is published to subscribers on close.
+type outputWriter struct {
+	ctx      context.Context
+	worker   db.TransactionWorker
+	running  *RunningCmd
+	logger   *log.Logger
+	maxSize  int
+	interval time.Duration
+	limiter  *outputLimiter
+
+	// flushMtx is held while the output is saved, so chunks are saved in order
+	flushMtx sync.Mutex
+	// savedTailSize is a size of the saved tail without the marker, protected by flushMtx
+	savedTailSize int64
+
+	// mtx to protect pending, pendingSize, limiter and err
+	mtx         sync.Mutex
+	pending     []db.OutputChunk
+	pendingSize int
+	// err is set if flush failed, nothing is saved after that
+	err error
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// newOutputWriter starts periodic flushing if interval is not 0.
+// Close should be called to stop it and to save the rest of the output.
+// limiter may be nil, then the whole output is saved.
+func newOutputWriter(
+	ctx context.Context,
+	worker db.TransactionWorker,
+	running *RunningCmd,
+	logger *log.Logger,
+	maxSize int,
+	interval time.Duration,
+	limiter *outputLimiter,
+) *outputWriter {
+	w := &outputWriter{
+		ctx:      ctx,
+		worker:   worker,
+		running:  running,
+		logger:   logger,
+		maxSize:  maxSize,
+		interval: interval,
+		limiter:  limiter,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	if interval == 0 {
+		close(w.stopped)
+		return w
+	}
+	go func() {
+		defer close(w.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+				_ = w.Flush()
+			}
+		}
+	}()
+	return w
+}
+
+// Write buffers copy of data. Adjacent chunks of the same stream are merged.
+// Returns error if some output was not saved. If the limit is exceeded
+// with KillOnLimit policy, the command is canceled.
+func (w *outputWriter) Write(stream db.OutputStream, data []byte) error {
+	w.mtx.Lock()
+	if w.err != nil {
+		w.mtx.Unlock()
+		return w.err
+	}
+	exceeded := false
+	if w.limiter != nil {
+		data, exceeded = w.limiter.add(stream, data)
+	}
+	if len(data) != 0 {
+		last := len(w.pending) - 1
+		if last >= 0 && w.pending[last].Stream == stream && !w.pending[last].Marker {
+			w.pending[last].Data = append(w.pending[last].Data, data...)
+		} else {
+			w.pending = append(w.pending, db.OutputChunk{Stream: stream, Data: append([]byte(nil), data...)})
+		}
+		w.pendingSize += len(data)
+	}
+	full := w.pendingSize >= w.maxSize || w.interval == 0
+	w.mtx.Unlock()
+
+	if exceeded {
+		w.logger.Printf("output limit exceeded, policy %s", w.limiter.policy)
+		if w.limiter.policy == db.KillOnLimit {
+			w.running.cancel(ErrOutputLimitExceeded)
+		}
+	}
+	if full {
+		return w.Flush()
+	}
+	return nil
+}
+
+// Flush saves buffered output and the changed tail in one transaction
+// and publishes buffered output to subscribers
+func (w *outputWriter) Flush() error {
+	return w.flush(false)
+}
+
+// flush saves buffered output and the tail. If final is true, the tail is saved even if it did not change
+// and is published to subscribers, because it is not changed anymore.
+func (w *outputWriter) flush(final bool) error {
+	w.flushMtx.Lock()
+	defer w.flushMtx.Unlock()
+
+	w.mtx.Lock()
+	chunks, size, err := w.pending, w.pendingSize, w.err
+	w.pending, w.pendingSize = nil, 0
+	var dropped int64
+	var tail []db.OutputChunk
+	replaceTail := false
+	if w.limiter != nil {
+		dropped = w.limiter.takeDropped()
+		if final {
+			tail = w.limiter.finish()
+			replaceTail = len(tail) != 0
+		} else {
+			tail, replaceTail = w.limiter.takeTail()
+		}
+	}
+	w.mtx.Unlock()
+	if err != nil || (len(chunks) == 0 && dropped == 0 && !replaceTail) {
+		return err
+	}
+
+	var tailSize int64
+	for _, chunk := range tail {
+		if !chunk.Marker {
+			tailSize += int64(len(chunk.Data))
+		}
+	}
+	ends := make([]int64, len(chunks))
+	tailEnds := make([]int64, len(tail))
+	err = w.worker(w.ctx, func(tx pgx.Tx) error {
+		var err error
+		if len(chunks) != 0 {
+			ends, err = db.AppendCommandOutputChunks(w.ctx, tx, w.running.Id, chunks)
+			if err != nil {
+				return err
+			}
+		}
+		if dropped != 0 {
+			err = db.AddCommandDroppedOutput(w.ctx, tx, w.running.Id, dropped)
+			if err != nil {
+				return err
+			}
+		}
+		if replaceTail {
+			tailEnds, err = db.ReplaceCommandOutputTail(w.ctx, tx, w.running.Id, w.savedTailSize, tail)
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Commit(w.ctx)
+	})
+	if err != nil {
+		w.logger.Printf("failed to append command output: %s", err)
+		w.mtx.Lock()
+		w.err = err
+		w.mtx.Unlock()
+		return err
+	}
+	w.logger.Printf("append %d bytes in %d chunks to command output, %d bytes dropped", size, len(chunks), dropped)
+	if replaceTail {
+		w.savedTailSize = tailSize
+		w.logger.Printf("replace tail of command output with %d bytes in %d chunks", tailSize, len(tail))
+	}
+
+	for i, chunk := range chunks {
+		w.running.Publish(CmdEvent{Stream: chunk.Stream, Data: string(chunk.Data), End: ends[i]})
+	}
+	if final {
+		for i, chunk := range tail {
+			w.running.Publish(CmdEvent{Stream: chunk.Stream, Data: string(chunk.Data), End: tailEnds[i]})
+		}
+	}
+	return nil
+}
+
+// Close stops periodic flushing and saves the rest of the output including the tail kept by limiter
+func (w *outputWriter) Close() error {
+	select {
+	case <-w.done:
+	default:
+		close(w.done)
+	}
+	<-w.stopped
+	return w.flush(true)
+}
@@ -0,0 +1,282 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"log"
+	"pg-test-task-2024/internal/config"
+	"pg-test-task-2024/internal/db"
+	"pg-test-task-2024/internal/db/dbtest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingTransactionWorker returns worker which counts transactions,
+// but does not call worker, so nothing is saved to db
+func countingTransactionWorker(count *atomic.Int64) db.TransactionWorker {
+	return func(ctx context.Context, worker func(tx pgx.Tx) error) error {
+		count.Add(1)
+		return nil
+	}
+}
+
+// newTestOutputWriter returns writer and chan with events published by the writer
+func newTestOutputWriter(
+	t *testing.T,
+	worker db.TransactionWorker,
+	maxSize int,
+	interval time.Duration,
+) (*outputWriter, <-chan CmdEvent) {
+	id := uuid.New()
+	hub := NewOutputHub()
+	events, unsubscribe := hub.Subscribe(id)
+	t.Cleanup(unsubscribe)
+
+	running := &RunningCmd{Id: id, hub: hub}
+	return newOutputWriter(context.Background(), worker, running, log.Default(), maxSize, interval, nil), events
+}
+
+// receiveEvents returns events which were already published
+func receiveEvents(events <-chan CmdEvent) []CmdEvent {
+	received := make([]CmdEvent, 0)
+	for {
+		select {
+		case event := <-events:
+			received = append(received, event)
+		default:
+			return received
+		}
+	}
+}
+
+func TestOutputWriter_MergesChunksOnClose(t *testing.T) {
+	var transactions atomic.Int64
+	writer, events := newTestOutputWriter(t, countingTransactionWorker(&transactions), 1024, time.Hour)
+
+	for _, chunk := range []db.OutputChunk{
+		{Stream: db.Stdout, Data: []byte("a")},
+		{Stream: db.Stdout, Data: []byte("b")},
+		{Stream: db.Stderr, Data: []byte("c")},
+		{Stream: db.Stdout, Data: []byte("d")},
+	} {
+		err := writer.Write(chunk.Stream, chunk.Data)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if got := receiveEvents(events); len(got) != 0 {
+		t.Fatalf("output is published before flush: %v", got)
+	}
+
+	err := writer.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if transactions.Load() != 1 {
+		t.Fatalf("got %d transactions, expected 1", transactions.Load())
+	}
+	expected := []CmdEvent{
+		{Stream: db.Stdout, Data: "ab"},
+		{Stream: db.Stderr, Data: "c"},
+		{Stream: db.Stdout, Data: "d"},
+	}
+	got := receiveEvents(events)
+	if len(got) != len(expected) {
+		t.Fatalf("got events %v, expected %v", got, expected)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("got events %v, expected %v", got, expected)
+		}
+	}
+}
+
+func TestOutputWriter_FlushesWhenSizeExceeded(t *testing.T) {
+	var transactions atomic.Int64
+	writer, events := newTestOutputWriter(t, countingTransactionWorker(&transactions), 4, time.Hour)
+	defer writer.Close()
+
+	_ = writer.Write(db.Stdout, []byte("ab"))
+	if transactions.Load() != 0 {
+		t.Fatalf("output is flushed before size exceeded")
+	}
+	_ = writer.Write(db.Stdout, []byte("cd"))
+	if transactions.Load() != 1 {
+		t.Fatalf("got %d transactions, expected 1", transactions.Load())
+	}
+	got := receiveEvents(events)
+	if len(got) != 1 || got[0].Data != "abcd" {
+		t.Fatalf("got events %v, expected one event with data %q", got, "abcd")
+	}
+}
+
+func TestOutputWriter_FlushesAfterInterval(t *testing.T) {
+	var transactions atomic.Int64
+	writer, events := newTestOutputWriter(t, countingTransactionWorker(&transactions), 1024, 10*time.Millisecond)
+	defer writer.Close()
+
+	_ = writer.Write(db.Stderr, []byte("data"))
+
+	select {
+	case event := <-events:
+		if event.Stream != db.Stderr || event.Data != "data" {
+			t.Fatalf("got event %v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("output is not flushed after interval")
+	}
+}
+
+func TestOutputWriter_ReplacesTailOnFlush(t *testing.T) {
+	var transactions atomic.Int64
+	writer, events := newTestOutputWriter(t, countingTransactionWorker(&transactions), 1024, time.Hour)
+	writer.limiter = newOutputLimiter(4, db.KeepTail)
+
+	_ = writer.Write(db.Stdout, []byte("abcdef"))
+	_ = writer.Flush()
+	if transactions.Load() != 1 {
+		t.Fatalf("got %d transactions, expected 1", transactions.Load())
+	}
+	_ = writer.Flush()
+	if transactions.Load() != 1 {
+		t.Fatalf("tail is saved again without changes")
+	}
+	if got := receiveEvents(events); len(got) != 0 {
+		t.Fatalf("tail is published before close: %v", got)
+	}
+
+	_ = writer.Write(db.Stdout, []byte("gh"))
+	err := writer.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if transactions.Load() != 2 {
+		t.Fatalf("got %d transactions, expected 2", transactions.Load())
+	}
+	got := receiveEvents(events)
+	if len(got) != 1 || got[0].Data != "efgh" {
+		t.Fatalf("got events %v, expected one event with data %q", got, "efgh")
+	}
+}
+
+func TestOutputWriter_SavesTailWhileRunning(t *testing.T) {
+	ctx := context.Background()
+	dbtest.CreateTestContainer(ctx, t)
+	pool, err := pgxpool.Connect(ctx, config.GetDbConnStr())
+	if err != nil {
+		t.Fatalf("failed to connect to db: %v", err)
+	}
+	worker := db.TransactionWorkerProvider(pool)
+
+	var id uuid.UUID
+	err = worker(ctx, func(tx pgx.Tx) error {
+		id, err = db.InsertNewCommand(ctx, tx, "#!/bin/sh\n", db.CommandOptions{})
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		t.Fatalf("failed to insert new command: %v", err)
+	}
+	running := &RunningCmd{Id: id, hub: NewOutputHub()}
+	writer := newOutputWriter(ctx, worker, running, log.Default(), 1024, time.Hour, newOutputLimiter(4, db.KeepTail))
+
+	for _, step := range []struct {
+		data     string
+		expected string
+	}{
+		{"abcdef", "cdef"},
+		{"gh", "efgh"},
+	} {
+		_ = writer.Write(db.Stdout, []byte(step.data))
+		err = writer.Flush()
+		if err != nil {
+			t.Fatalf("failed to flush: %v", err)
+		}
+		var entity db.CommandEntity
+		err = worker(ctx, func(tx pgx.Tx) error {
+			entity, err = db.GetSingleCommand(ctx, tx, id)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("failed to get command: %v", err)
+		}
+		if string(entity.Output) != step.expected {
+			t.Fatalf("got output %q, expected %q", entity.Output, step.expected)
+		}
+		if !entity.OutputTruncated {
+			t.Fatalf("output should be truncated")
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatalf("failed to close writer: %v", err)
+	}
+	var entity db.CommandEntity
+	err = worker(ctx, func(tx pgx.Tx) error {
+		entity, err = db.GetSingleCommand(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to get command: %v", err)
+	}
+	if string(entity.Output) != "efgh" || entity.OutputTotalBytes != 8 {
+		t.Fatalf("got output %q of %d bytes, expected %q of %d bytes", entity.Output, entity.OutputTotalBytes, "efgh", 8)
+	}
+}
+
+func TestOutputWriter_ReturnsFlushError(t *testing.T) {
+	failingWorker := func(ctx context.Context, worker func(tx pgx.Tx) error) error {
+		return fmt.Errorf("db is down")
+	}
+	writer, _ := newTestOutputWriter(t, failingWorker, 1, time.Hour)
+
+	err := writer.Write(db.Stdout, []byte("a"))
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	err = writer.Write(db.Stdout, []byte("b"))
+	if err == nil {
+		t.Fatalf("expected error after failed flush")
+	}
+	err = writer.Close()
+	if err == nil {
+		t.Fatalf("expected error on close after failed flush")
+	}
+}
+
+// BenchmarkDefaultRunner_ChattyScript runs script printing many short lines. Metric tx/op
+// is a number of transactions used to save the output, batching reduces it by orders of magnitude.
+func BenchmarkDefaultRunner_ChattyScript(b *testing.B) {
+	script := "#!/bin/sh\ni=0\nwhile [ $i -lt 10000 ]; do echo \"line $i\"; i=$((i+1)); done\n"
+	for _, bc := range []struct {
+		name     string
+		interval string
+	}{
+		{"unbatched", "0s"},
+		{"batched", "100ms"},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.Setenv("EXECUTOR_OUTPUT_FLUSH_INTERVAL", bc.interval)
+			b.Setenv("EXECUTOR_CMD_DIR", b.TempDir())
+			b.Setenv("EXECUTOR_WORKSPACE_DIR", b.TempDir())
+			b.Setenv("EXECUTOR_ARTIFACTS_DIR", b.TempDir())
+			id := writeScriptFile(b, script)
+
+			var transactions atomic.Int64
+			worker := countingTransactionWorker(&transactions)
+			for i := 0; i < b.N; i++ {
+				running := &RunningCmd{Id: id, hub: NewOutputHub()}
+				defaultRunner(context.Background(), running, worker)
+			}
+			b.ReportMetric(float64(transactions.Load())/float64(b.N), "tx/op")
+		})
+	}
+}
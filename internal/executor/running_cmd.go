@@ -0,0 +1,176 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"io"
+	"os"
+	"pg-test-task-2024/internal/db"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RunningCmd is created by Executor for each command and passed to the runner.
+// It connects the runner with the executor and subscribers of the command.
+type RunningCmd struct {
+	Id uuid.UUID
+
+	// Options are loaded by the executor before the runner is called
+	Options db.CommandOptions
+
+	cancel context.CancelCauseFunc
+	hub    *OutputHub
+
+	// mtx to protect stdin, stdinAttached and cancel state
+	mtx sync.Mutex
+
+	// stdin is set by the runner if the command is interactive
+	stdin io.WriteCloser
+
+	// stdinAttached is true if some client writes to stdin
+	stdinAttached bool
+
+	// cancelRequest is set when the command is canceled by the client
+	cancelRequest *CancelRequest
+
+	// killNow is closed if SIGKILL is requested during the grace period
+	killNow chan struct{}
+
+	// escalated is true if the process group was killed after the grace period
+	escalated bool
+}
+
+// Publish sends event to all subscribers of the command
+func (c *RunningCmd) Publish(event CmdEvent) {
+	c.hub.Publish(c.Id, event)
+}
+
+// SetStdin makes stdin of the process available for attaching.
+// Nil stdin means that it is not available anymore.
+func (c *RunningCmd) SetStdin(stdin io.WriteCloser) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.stdin = stdin
+}
+
+// requestCancel remembers how the command should be canceled and cancels its context.
+// If the command is already being canceled, only SIGKILL may be requested,
+// which ends the grace period.
+func (c *RunningCmd) requestCancel(req CancelRequest) error {
+	c.mtx.Lock()
+	if c.cancelRequest != nil {
+		defer c.mtx.Unlock()
+		if req.Signal != syscall.SIGKILL || c.cancelRequest.Signal == syscall.SIGKILL {
+			return ErrAlreadyCanceled
+		}
+		select {
+		case <-c.killNow:
+			return ErrAlreadyCanceled
+		default:
+			close(c.killNow)
+			return nil
+		}
+	}
+	c.cancelRequest = &req
+	c.killNow = make(chan struct{})
+	c.mtx.Unlock()
+
+	c.cancel(ErrCanceled)
+	return nil
+}
+
+// CancelSignal returns signal requested on cancel, 0 if the command was not canceled by the client
+func (c *RunningCmd) CancelSignal() syscall.Signal {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.cancelRequest == nil {
+		return 0
+	}
+	return c.cancelRequest.Signal
+}
+
+// Escalated returns true if the process group was killed after the grace period
+func (c *RunningCmd) Escalated() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.escalated
+}
+
+// terminate is called by the runner when its context is done with the cause.
+// If the command was canceled by the client, the process group gets requested signal
+// and is killed if it does not exit within the grace period. Otherwise, the group
+// is killed at once. exited should be closed when the process exits.
+func (c *RunningCmd) terminate(cause error, process *os.Process, exited <-chan struct{}) error {
+	req := CancelRequest{Signal: syscall.SIGKILL}
+	var killNow <-chan struct{}
+	c.mtx.Lock()
+	if errors.Is(cause, ErrCanceled) && c.cancelRequest != nil {
+		req = *c.cancelRequest
+		killNow = c.killNow
+	}
+	c.mtx.Unlock()
+
+	err := signalProcessGroup(process, req.Signal)
+	if err != nil || req.Signal == syscall.SIGKILL {
+		return err
+	}
+	go func() {
+		timer := time.NewTimer(req.GracePeriod)
+		defer timer.Stop()
+		select {
+		case <-exited:
+			return
+		case <-timer.C:
+		case <-killNow:
+		}
+		c.mtx.Lock()
+		c.escalated = true
+		c.mtx.Unlock()
+		_ = signalProcessGroup(process, syscall.SIGKILL)
+	}()
+	return nil
+}
+
+// attachStdin returns stdin of the process and function to detach.
+// Only one client may be attached at a time. Closing returned stdin
+// closes stdin of the process, so it can't be attached anymore.
+func (c *RunningCmd) attachStdin() (io.WriteCloser, func(), error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.stdin == nil {
+		return nil, nil, ErrNotInteractive
+	}
+	if c.stdinAttached {
+		return nil, nil, ErrAlreadyAttached
+	}
+	c.stdinAttached = true
+
+	detachOnce := sync.Once{}
+	return &attachedStdin{cmd: c, stdin: c.stdin}, func() {
+		detachOnce.Do(func() {
+			c.mtx.Lock()
+			defer c.mtx.Unlock()
+			c.stdinAttached = false
+		})
+	}, nil
+}
+
+type attachedStdin struct {
+	cmd   *RunningCmd
+	stdin io.WriteCloser
+}
+
+func (a *attachedStdin) Write(p []byte) (int, error) {
+	return a.stdin.Write(p)
+}
+
+func (a *attachedStdin) Close() error {
+	a.cmd.mtx.Lock()
+	if a.cmd.stdin == a.stdin {
+		a.cmd.stdin = nil
+	}
+	a.cmd.mtx.Unlock()
+	return a.stdin.Close()
+}
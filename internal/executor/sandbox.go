@@ -0,0 +1,197 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// oPath is O_PATH, it is not defined in syscall package
+	oPath           = 0x200000
+	prSetSecurebits = 28
+	prSetNoNewPrivs = 38
+	// secbitNoRoot prevents root from getting capabilities on exec
+	secbitNoRoot       = 1 << 0
+	secbitNoRootLocked = 1 << 1
+)
+
+// mount_setattr is not defined in syscall package
+const (
+	sysMountSetattr = 442
+	atFdCwd         = -100
+	atRecursive     = 0x8000
+	mountAttrRdOnly = 0x1
+)
+
+// mountAttr is struct mount_attr of mount_setattr
+type mountAttr struct {
+	attrSet     uint64
+	attrClr     uint64
+	propagation uint64
+	usernsFd    uint64
+}
+
+// sandboxMounts describe paths visible to the script in the sandbox
+type sandboxMounts struct {
+	// workspace is the only writable directory except private /tmp
+	workspace string
+	// readOnly paths stay visible even if they are in /tmp
+	readOnly []string
+}
+
+// setSandboxAttr makes the shim start in new user, mount, PID and network namespaces.
+// The shim is root in the user namespace, which is mapped to the script user,
+// so it may mount file systems, but has no privileges outside the namespaces.
+func setSandboxAttr(attr *syscall.SysProcAttr, credential *syscall.Credential) {
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+	if credential != nil {
+		uid, gid = credential.Uid, credential.Gid
+	}
+	attr.Cloneflags = syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNET
+	attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: int(uid), Size: 1}}
+	attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: int(gid), Size: 1}}
+	// only privileged server may drop supplementary groups in the user namespace
+	privileged := os.Geteuid() == 0
+	attr.GidMappingsEnableSetgroups = privileged
+	attr.Credential = &syscall.Credential{Uid: 0, Gid: 0, Groups: []uint32{}, NoSetGroups: !privileged}
+}
+
+// enterSandbox is called by the shim in new namespaces. Root and all submounts are made read-only,
+// /tmp is replaced with empty tmpfs, new /proc shows only processes of the script.
+// Capabilities of the shim are not passed to the script.
+func enterSandbox(mounts sandboxMounts) error {
+	// nothing is propagated to the mount namespace of the server
+	err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, "")
+	if err != nil {
+		return fmt.Errorf("failed to make mounts private: %w", err)
+	}
+
+	// paths are opened before /tmp is replaced, because they may be in it
+	binds := make([]bindMount, 0, len(mounts.readOnly)+1)
+	defer func() {
+		for _, b := range binds {
+			_ = syscall.Close(b.fd)
+		}
+	}()
+	for i, path := range append([]string{mounts.workspace}, mounts.readOnly...) {
+		fd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		binds = append(binds, bindMount{path: path, fd: fd, readOnly: i != 0})
+	}
+
+	// all mounts including /dev/shm and volumes become read-only,
+	// writable mounts of the sandbox are mounted on top
+	err = setMountReadOnly("/", true, true)
+	if err != nil {
+		return fmt.Errorf("failed to make root read-only: %w", err)
+	}
+	err = syscall.Mount("tmpfs", "/tmp", "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777")
+	if err != nil {
+		return fmt.Errorf("failed to mount /tmp: %w", err)
+	}
+	for _, b := range binds {
+		err = b.mount()
+		if err != nil {
+			return err
+		}
+	}
+	err = syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+	if err != nil {
+		return fmt.Errorf("failed to mount /proc: %w", err)
+	}
+	// working directory should be on the writable mount
+	err = os.Chdir(mounts.workspace)
+	if err != nil {
+		return err
+	}
+	return dropPrivileges()
+}
+
+// bindMount makes the opened path visible at the same place after /tmp is replaced
+type bindMount struct {
+	path     string
+	fd       int
+	readOnly bool
+}
+
+func (b bindMount) mount() error {
+	var stat syscall.Stat_t
+	err := syscall.Fstat(b.fd, &stat)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", b.path, err)
+	}
+	// mount point is created in /tmp if the path is hidden by it
+	if stat.Mode&syscall.S_IFMT == syscall.S_IFDIR {
+		err = os.MkdirAll(b.path, 0755)
+	} else {
+		err = os.MkdirAll(filepath.Dir(b.path), 0755)
+		if err == nil {
+			var f *os.File
+			f, err = os.OpenFile(b.path, os.O_RDONLY|os.O_CREATE, 0644)
+			if err == nil {
+				_ = f.Close()
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create mount point %s: %w", b.path, err)
+	}
+
+	err = syscall.Mount(fmt.Sprintf("/proc/self/fd/%d", b.fd), b.path, "", syscall.MS_BIND, "")
+	if err != nil {
+		return fmt.Errorf("failed to mount %s: %w", b.path, err)
+	}
+	// bind mount is read-only as its source, so the workspace is made writable
+	err = setMountReadOnly(b.path, b.readOnly, false)
+	if err != nil {
+		return fmt.Errorf("failed to change read-only attribute of %s: %w", b.path, err)
+	}
+	return nil
+}
+
+// setMountReadOnly changes only read-only attribute of the mount at path, other attributes
+// may be locked in the user namespace. If recursive, submounts are changed too.
+func setMountReadOnly(path string, readOnly, recursive bool) error {
+	attr := mountAttr{}
+	if readOnly {
+		attr.attrSet = mountAttrRdOnly
+	} else {
+		attr.attrClr = mountAttrRdOnly
+	}
+	flags := 0
+	if recursive {
+		flags = atRecursive
+	}
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	dirFd := atFdCwd
+	_, _, errno := syscall.Syscall6(sysMountSetattr, uintptr(dirFd), uintptr(unsafe.Pointer(p)),
+		uintptr(flags), uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// dropPrivileges makes exec of the script not grant capabilities, though the script is root
+// in the user namespace. Securebits are set for the current thread, so the shim locks the thread
+// which executes the script.
+func dropPrivileges() error {
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetSecurebits,
+		secbitNoRoot|secbitNoRootLocked, 0, 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to set securebits: %w", errno)
+	}
+	_, _, errno = syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to set no new privileges: %w", errno)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package executor
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// envPaths are paths of env, which may be used in shebang to find interpreter by name
+var envPaths = []string{"/usr/bin/env", "/bin/env"}
+
+// Interpreter is a program which executes the script
+type Interpreter struct {
+	// Path is an absolute path to the interpreter from the allowlist
+	Path string
+
+	// Arg is an optional argument from the shebang line
+	Arg string
+}
+
+// Args returns arguments for exec to run the script file with interpreter
+func (i Interpreter) Args(fname string, scriptArgs []string) []string {
+	args := make([]string, 0, len(scriptArgs)+2)
+	if i.Arg != "" {
+		args = append(args, i.Arg)
+	}
+	args = append(args, fname)
+	return append(args, scriptArgs...)
+}
+
+// ParseShebang finds interpreter of the script in allowlist (absolute paths of interpreters).
+// Shebang may contain absolute path of the interpreter with an optional argument
+// (#!/bin/bash -e) or name of the interpreter passed to env (#!/usr/bin/env python3).
+// In the latter case interpreter from allowlist with such name is used.
+func ParseShebang(script string, allowlist []string) (Interpreter, error) {
+	line, _, _ := strings.Cut(script, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	after, found := strings.CutPrefix(line, "#!")
+	if !found {
+		return Interpreter{}, ErrNoShebang
+	}
+
+	fields := strings.Fields(after)
+	if len(fields) == 0 {
+		return Interpreter{}, ErrNoShebang
+	}
+	path := fields[0]
+	// like the kernel, pass the rest of the line as a single argument
+	arg := strings.Join(fields[1:], " ")
+
+	for _, envPath := range envPaths {
+		if path != envPath {
+			continue
+		}
+		if len(fields) != 2 {
+			return Interpreter{}, fmt.Errorf("%w: %s should be followed by interpreter name only",
+				ErrUnknownInterpreter, path)
+		}
+		for _, allowed := range allowlist {
+			if filepath.Base(allowed) == arg {
+				return Interpreter{Path: allowed}, nil
+			}
+		}
+		return Interpreter{}, fmt.Errorf("%w: %s", ErrUnknownInterpreter, arg)
+	}
+
+	for _, allowed := range allowlist {
+		if allowed == path {
+			return Interpreter{Path: path, Arg: arg}, nil
+		}
+	}
+	return Interpreter{}, fmt.Errorf("%w: %s", ErrUnknownInterpreter, path)
+}
@@ -0,0 +1,72 @@
+package executor
+
+import (
+	"errors"
+	"testing"
+)
+
+var testAllowlist = []string{"/bin/sh", "/bin/bash", "/usr/bin/python3"}
+
+func TestParseShebang(t *testing.T) {
+	testCases := []struct {
+		script   string
+		expected Interpreter
+	}{
+		{"#!/bin/sh\necho hi\n", Interpreter{Path: "/bin/sh"}},
+		{"#!/bin/bash\r\necho hi\r\n", Interpreter{Path: "/bin/bash"}},
+		{"#! /bin/bash -e\necho hi\n", Interpreter{Path: "/bin/bash", Arg: "-e"}},
+		{"#!/usr/bin/env python3\nprint('hi')\n", Interpreter{Path: "/usr/bin/python3"}},
+		{"#!/bin/env bash\necho hi\n", Interpreter{Path: "/bin/bash"}},
+		{"#!/bin/sh", Interpreter{Path: "/bin/sh"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.script, func(t *testing.T) {
+			got, err := ParseShebang(tc.script, testAllowlist)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.expected {
+				t.Fatalf("got interpreter %v, expected %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestParseShebang_WithBadShebang(t *testing.T) {
+	testCases := []struct {
+		script      string
+		expectedErr error
+	}{
+		{"", ErrNoShebang},
+		{"echo hi\n", ErrNoShebang},
+		{"#!\necho hi\n", ErrNoShebang},
+		{"\n#!/bin/sh\n", ErrNoShebang},
+		{"#!/bins/sh\n", ErrUnknownInterpreter},
+		{"#!/usr/bin/perl\n", ErrUnknownInterpreter},
+		{"#!sh\n", ErrUnknownInterpreter},
+		{"#!/usr/bin/env perl\n", ErrUnknownInterpreter},
+		{"#!/usr/bin/env\n", ErrUnknownInterpreter},
+		{"#!/usr/bin/env bash -e\n", ErrUnknownInterpreter},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.script, func(t *testing.T) {
+			_, err := ParseShebang(tc.script, testAllowlist)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("got error %v, expected %v", err, tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestInterpreter_Args(t *testing.T) {
+	got := Interpreter{Path: "/bin/bash", Arg: "-e"}.Args("/tmp/script", []string{"a", "b"})
+	expected := []string{"-e", "/tmp/script", "a", "b"}
+	if len(got) != len(expected) {
+		t.Fatalf("got args %v, expected %v", got, expected)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("got args %v, expected %v", got, expected)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// shimArg is the first argument of the executor started as a shim. The shim applies
+// rlimits to itself, prepares the sandbox and executes the script,
+// so everything is set before the script starts. In the sandbox the shim stays PID 1
+// and the script is its child. Rlimits are sized for the script, so PID 1 does not apply
+// them to itself, it starts another shim, which applies them and executes the script.
+const shimArg = "__executor-shim"
+
+// selfExe is used to start the shim in the sandbox, where the path of the executable may be hidden
+const selfExe = "/proc/self/exe"
+
+// options of the shim, other options are names of rlimits
+const (
+	workspaceOption = "workspace"
+	readOnlyOption  = "ro"
+)
+
+// shimOptions describe what the shim should do before executing the script
+type shimOptions struct {
+	limits []rlimit
+	// sandbox is nil if the script is not executed in the sandbox
+	sandbox *sandboxMounts
+}
+
+// needed returns false if the script may be executed directly
+func (o shimOptions) needed() bool {
+	return len(o.limits) != 0 || o.sandbox != nil
+}
+
+// shimCommand returns path and arguments of the shim, which executes path with args
+func shimCommand(opts shimOptions, path string, args []string) (string, []string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return "", nil, err
+	}
+	return self, shimArgs(opts, path, args), nil
+}
+
+// shimArgs returns arguments of the shim (without the name of the program), which executes path with args
+func shimArgs(opts shimOptions, path string, args []string) []string {
+	shimArgs := []string{shimArg}
+	if opts.sandbox != nil {
+		shimArgs = append(shimArgs, workspaceOption+"="+opts.sandbox.workspace)
+		for _, path := range opts.sandbox.readOnly {
+			shimArgs = append(shimArgs, readOnlyOption+"="+path)
+		}
+	}
+	for _, l := range opts.limits {
+		shimArgs = append(shimArgs, fmt.Sprintf("%s=%d", l.name, l.value))
+	}
+	shimArgs = append(shimArgs, "--", path)
+	return append(shimArgs, args...)
+}
+
+// RunShimIfRequested should be called at the start of the program. If the program
+// is started as a shim, the script is executed and the function never returns.
+func RunShimIfRequested() {
+	if len(os.Args) < 2 || os.Args[1] != shimArg {
+		return
+	}
+	// the sandbox drops privileges of the thread, which executes the script
+	runtime.LockOSThread()
+	err := runShim(os.Args[2:])
+	_, _ = fmt.Fprintf(os.Stderr, "executor: %s\n", err)
+	// the same code as used by shells if the command is not executable
+	os.Exit(126)
+}
+
+// runShim returns only on error
+func runShim(args []string) error {
+	opts := shimOptions{}
+	for len(args) > 0 && args[0] != "--" {
+		name, value, ok := strings.Cut(args[0], "=")
+		if !ok {
+			return fmt.Errorf("invalid option %q", args[0])
+		}
+		switch name {
+		case workspaceOption:
+			if opts.sandbox == nil {
+				opts.sandbox = &sandboxMounts{}
+			}
+			opts.sandbox.workspace = value
+		case readOnlyOption:
+			if opts.sandbox == nil {
+				opts.sandbox = &sandboxMounts{}
+			}
+			opts.sandbox.readOnly = append(opts.sandbox.readOnly, value)
+		default:
+			_, known := rlimitResources[name]
+			n, err := strconv.ParseInt(value, 10, 64)
+			if !known || err != nil || n < 0 {
+				return fmt.Errorf("invalid limit %q", args[0])
+			}
+			opts.limits = append(opts.limits, rlimit{name, n})
+		}
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		return errors.New("script is not specified")
+	}
+
+	if opts.sandbox != nil {
+		if opts.sandbox.workspace == "" {
+			return errors.New("workspace of the sandbox is not specified")
+		}
+		err := enterSandbox(*opts.sandbox)
+		if err != nil {
+			return err
+		}
+		return runAsInit(opts.limits, args[1], args[1:])
+	}
+	// the shim is replaced with the script right after rlimits are set,
+	// address space is the last one, so the runtime of the shim has to allocate nothing after it
+	for _, l := range opts.limits {
+		value := uint64(l.value)
+		err := syscall.Setrlimit(rlimitResources[l.name], &syscall.Rlimit{Cur: value, Max: value})
+		if err != nil {
+			return fmt.Errorf("failed to set %s limit: %w", l.name, err)
+		}
+	}
+	return syscall.Exec(args[1], args[1:], os.Environ())
+}
+
+// runAsInit starts the script as a child of the shim, which stays PID 1 of the sandbox.
+// If limits are set, the child is another shim, which applies them and executes the script,
+// so the long-lived PID 1 is not limited.
+// PID 1 does not get signals it has no handler for, so the shim forwards them to the process
+// group of the script, reaps orphaned processes and exits with the status of the script.
+// If the script is killed by a signal, the exit code is 128 plus the signal number, as in shells,
+// because PID 1 can't kill itself with a signal.
+func runAsInit(limits []rlimit, path string, args []string) error {
+	if len(limits) != 0 {
+		args = append([]string{selfExe}, shimArgs(shimOptions{limits: limits}, path, args[1:])...)
+		path = selfExe
+	}
+	signals := make(chan os.Signal, len(cancelSignals))
+	// SIGKILL can't be handled, it kills the shim and so the whole PID namespace
+	for _, sig := range cancelSignals {
+		if sig != syscall.SIGKILL {
+			signal.Notify(signals, sig)
+		}
+	}
+	process, err := os.StartProcess(path, args, &os.ProcAttr{
+		Env:   os.Environ(),
+		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
+		Sys:   &syscall.SysProcAttr{Setpgid: true},
+	})
+	if err != nil {
+		return err
+	}
+	go func() {
+		for sig := range signals {
+			_ = syscall.Kill(-process.Pid, sig.(syscall.Signal))
+		}
+	}()
+
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, 0, nil)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to wait for script: %w", err)
+		}
+		if pid != process.Pid {
+			// orphaned descendant of the script
+			continue
+		}
+		if status.Signaled() {
+			os.Exit(128 + int(status.Signal()))
+		}
+		os.Exit(status.ExitStatus())
+	}
+}
@@ -0,0 +1,51 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cancelSignals are signals which may be requested on cancel
+var cancelSignals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// ParseSignal returns signal which may be requested on cancel by its name.
+// Name is case-insensitive, SIG prefix may be omitted, e.g. SIGTERM, term.
+func ParseSignal(name string) (syscall.Signal, error) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig, ok := cancelSignals[name]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownSignal, name)
+	}
+	return sig, nil
+}
+
+// SignalName returns name of the signal, e.g. SIGTERM
+func SignalName(sig syscall.Signal) string {
+	for name, s := range cancelSignals {
+		if s == sig {
+			return name
+		}
+	}
+	return fmt.Sprintf("signal %d", int(sig))
+}
+
+// CancelRequest describes how the command should be canceled. Signal is sent
+// to the process group of the command, if the command does not exit within
+// GracePeriod, the group is killed. Zero Signal means SIGKILL.
+type CancelRequest struct {
+	Signal      syscall.Signal
+	GracePeriod time.Duration
+}
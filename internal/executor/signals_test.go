@@ -0,0 +1,71 @@
+package executor
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+func TestParseSignal(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected syscall.Signal
+	}{
+		{"SIGTERM", syscall.SIGTERM},
+		{"sigint", syscall.SIGINT},
+		{"HUP", syscall.SIGHUP},
+		{"kill", syscall.SIGKILL},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseSignal(tc.name)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.expected {
+				t.Fatalf("got signal %v, expected %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestParseSignal_WithUnknownSignal(t *testing.T) {
+	for _, name := range []string{"", "SIG", "SIGSTOP", "SIGSEGV", "15", "TERM "} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseSignal(name)
+			if !errors.Is(err, ErrUnknownSignal) {
+				t.Fatalf("got error %v, expected %v", err, ErrUnknownSignal)
+			}
+		})
+	}
+}
+
+func TestRunningCmd_RequestCancel_Twice(t *testing.T) {
+	canceled := 0
+	running := &RunningCmd{cancel: func(cause error) { canceled++ }}
+
+	err := running.requestCancel(CancelRequest{Signal: syscall.SIGTERM})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = running.requestCancel(CancelRequest{Signal: syscall.SIGINT})
+	if !errors.Is(err, ErrAlreadyCanceled) {
+		t.Fatalf("got error %v, expected %v", err, ErrAlreadyCanceled)
+	}
+	// SIGKILL ends the grace period
+	err = running.requestCancel(CancelRequest{Signal: syscall.SIGKILL})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = running.requestCancel(CancelRequest{Signal: syscall.SIGKILL})
+	if !errors.Is(err, ErrAlreadyCanceled) {
+		t.Fatalf("got error %v, expected %v", err, ErrAlreadyCanceled)
+	}
+
+	if canceled != 1 {
+		t.Fatalf("context canceled %d times, expected once", canceled)
+	}
+	if sig := running.CancelSignal(); sig != syscall.SIGTERM {
+		t.Fatalf("cancel signal does not match: got %v, expected %v", sig, syscall.SIGTERM)
+	}
+}
@@ -0,0 +1,141 @@
+package executor
+
+import (
+	"github.com/google/uuid"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"pg-test-task-2024/internal/config"
+	"syscall"
+	"time"
+)
+
+// workspaceCleanupInterval is how often workspaces are checked for expiration
+const workspaceCleanupInterval = time.Minute
+
+// createWorkspace creates working directory of the command owned by the script user.
+// Other scripts may not list the directory with workspaces.
+func createWorkspace(id uuid.UUID, credential *syscall.Credential) (string, error) {
+	err := os.MkdirAll(config.GetWorkspaceDir(), 0711)
+	if err != nil {
+		return "", err
+	}
+	path := config.GetWorkspaceDir() + id.String()
+	err = os.Mkdir(path, 0700)
+	if err != nil {
+		return "", err
+	}
+	if credential != nil {
+		err = os.Chown(path, int(credential.Uid), int(credential.Gid))
+		if err != nil {
+			_ = os.Remove(path)
+			return "", err
+		}
+	}
+	return path, nil
+}
+
+// InputFilesDir returns directory in which files uploaded with the script are kept until the command starts
+func InputFilesDir(id uuid.UUID) string {
+	return config.GetCmdDir() + id.String() + ".files"
+}
+
+// RemoveCmdFiles removes the script and files uploaded with it
+func RemoveCmdFiles(id uuid.UUID) {
+	_ = os.Remove(config.GetCmdDir() + id.String())
+	_ = os.RemoveAll(InputFilesDir(id))
+}
+
+// placeInputFiles copies files uploaded with the script to the workspace,
+// copies are owned by the script user
+func placeInputFiles(src, workspace string, credential *syscall.Credential) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		err = copyInputFile(filepath.Join(src, entry.Name()), filepath.Join(workspace, entry.Name()), credential)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyInputFile(src, dst string, credential *syscall.Credential) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil && credential != nil {
+		err = out.Chown(int(credential.Uid), int(credential.Gid))
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// releaseWorkspace removes the workspace of the done command if it should not be retained.
+// Otherwise, its modification time is updated, so it is removed after retention period.
+func releaseWorkspace(path string, logger *log.Logger) {
+	if config.GetWorkspaceRetention() == 0 {
+		err := os.RemoveAll(path)
+		if err != nil {
+			logger.Printf("failed to remove workspace: %s", err)
+		}
+		return
+	}
+	now := time.Now()
+	err := os.Chtimes(path, now, now)
+	if err != nil {
+		logger.Printf("failed to update modification time of workspace: %s", err)
+	}
+}
+
+// removeExpiredWorkspaces removes workspaces of not running commands, which were not modified
+// for retention period. Only directories named by id of the command are removed, so
+// workspaces left after restart of the server are also removed.
+func (e *Executor) removeExpiredWorkspaces(now time.Time) {
+	dir := config.GetWorkspaceDir()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			e.logger.Printf("failed to read workspaces: %s", err)
+		}
+		return
+	}
+	retention := config.GetWorkspaceRetention()
+	for _, entry := range entries {
+		id, err := uuid.Parse(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		e.mtx.Lock()
+		_, running := e.runningCommands[id]
+		e.mtx.Unlock()
+		if running {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || now.Sub(info.ModTime()) < retention {
+			continue
+		}
+		err = os.RemoveAll(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			e.logger.Printf("failed to remove workspace %s: %s", entry.Name(), err)
+			continue
+		}
+		e.logger.Printf("removed expired workspace %s", entry.Name())
+	}
+}
@@ -0,0 +1,54 @@
+package executor
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"os"
+	"path/filepath"
+	"pg-test-task-2024/internal/db"
+	"testing"
+	"time"
+)
+
+func TestExecutor_RemovesExpiredWorkspaces(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("EXECUTOR_WORKSPACE_DIR", dir)
+	t.Setenv("EXECUTOR_WORKSPACE_RETENTION", "1h")
+	now := time.Now()
+
+	running, expired, retained := uuid.New(), uuid.New(), uuid.New()
+	for name, modTime := range map[string]time.Time{
+		running.String():  now.Add(-2 * time.Hour),
+		expired.String():  now.Add(-2 * time.Hour),
+		retained.String(): now.Add(-time.Minute),
+		"not-workspace":   now.Add(-2 * time.Hour),
+	} {
+		path := filepath.Join(dir, name)
+		err := os.Mkdir(path, 0700)
+		if err == nil {
+			err = os.Chtimes(path, modTime, modTime)
+		}
+		if err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+	}
+
+	e := New(nil, nil, func(ctx context.Context, running *RunningCmd, worker db.TransactionWorker) {})
+	e.runningCommands[running] = &RunningCmd{Id: running}
+	e.removeExpiredWorkspaces(now)
+
+	for name, kept := range map[string]bool{
+		running.String():  true,
+		expired.String():  false,
+		retained.String(): true,
+		"not-workspace":   true,
+	} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if kept && err != nil {
+			t.Fatalf("%s should be kept: %v", name, err)
+		}
+		if !kept && !os.IsNotExist(err) {
+			t.Fatalf("%s should be removed: %v", name, err)
+		}
+	}
+}
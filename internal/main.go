@@ -17,6 +17,7 @@ import (
 	"pg-test-task-2024/internal/db"
 	"pg-test-task-2024/internal/db/migrations"
 	"pg-test-task-2024/internal/executor"
+	"strings"
 	"time"
 )
 
@@ -30,7 +31,7 @@ func prepareDB(ctx context.Context, worker db.TransactionWorker) {
 		}
 
 		for _, id := range ids {
-			_ = os.Remove(config.GetCmdDir() + id.String())
+			executor.RemoveCmdFiles(id)
 		}
 		foundCmds = len(ids)
 
@@ -66,6 +67,40 @@ func Main() {
 
 	prepareDB(ctx, db.TransactionWorkerProvider(pool))
 
+	log.Printf("default command timeout: %s, max command timeout: %s (0s means no timeout)",
+		config.GetDefaultTimeout(), config.GetMaxTimeout())
+	log.Printf("default cancel grace period: %s, max cancel grace period: %s",
+		config.GetDefaultGracePeriod(), config.GetMaxGracePeriod())
+	log.Printf("max output size: %d bytes", config.GetMaxOutputSize())
+	log.Printf("output is saved every %s or after %d bytes",
+		config.GetOutputFlushInterval(), config.GetOutputFlushSize())
+	log.Printf("max running commands: %d", config.GetMaxRunningCmds())
+	log.Printf("resource limits: memory %d bytes, cpu %g cores, pids %d, open files %d, file size %d bytes (0 means no limit)",
+		config.GetMemoryLimit(), config.GetCPULimit(), config.GetPidsLimit(),
+		config.GetNoFileLimit(), config.GetFileSizeLimit())
+	if root := config.GetCgroupRoot(); root != "" {
+		log.Printf("cgroups of commands are created in %s", root)
+	} else {
+		log.Printf("cgroup root is not set, only open files, file size, memory and pids are limited with rlimits")
+	}
+
+	if users := config.GetScriptUsers(); users != nil {
+		log.Printf("scripts are executed with %d UIDs starting from %d, GID %d",
+			len(users.UIDs), users.UIDs[0], users.GID)
+	} else {
+		log.Printf("script UID and GID are not set, scripts are executed as the server user")
+	}
+
+	log.Printf("commands are executed in the sandbox by default: %v", config.GetSandboxDefault())
+	log.Printf("allowed interpreters: %s", strings.Join(config.GetInterpreters(), ", "))
+	log.Printf("environment variables passed to scripts: %s", strings.Join(config.GetEnvAllowlist(), ", "))
+	log.Printf("max request size: %d bytes, max input files: %d, max input file size: %d bytes, max total size: %d bytes",
+		config.GetMaxRequestSize(), config.GetMaxInputFiles(), config.GetMaxInputFileSize(), config.GetMaxInputFilesSize())
+	log.Printf("workspaces are created in %s and kept for %s after exit",
+		config.GetWorkspaceDir(), config.GetWorkspaceRetention())
+	log.Printf("artifacts are stored in %s, max artifact size: %d bytes, max total size: %d bytes",
+		config.GetArtifactsDir(), config.GetMaxArtifactSize(), config.GetMaxArtifactsSize())
+
 	exe := executor.New(toExecChan, db.TransactionWorkerProvider(pool), nil)
 	exe.Start(ctx)
 
@@ -76,9 +111,9 @@ func Main() {
 	r := api.ConfigureEndpoints(
 		db.TransactionWorkerProvider(pool),
 		executor.SubmitterProvider(toExecChan),
-		func(id uuid.UUID) error {
-			return exe.CancelCmd(id)
-		},
+		exe.CancelCmd,
+		exe.Subscribe,
+		exe.AttachStdin,
 	)
 	server := &http.Server{
 		Addr:    fmt.Sprintf("%s:%s", host, port),
@@ -106,4 +141,6 @@ func Main() {
 		log.Printf("Error shutting down server gracefully: %v", err)
 	}
 	cancel()
+	log.Println("waiting for running commands to be killed...")
+	exe.Wait()
 }
@@ -1,7 +1,12 @@
 package main
 
-import "pg-test-task-2024/internal"
+import (
+	"pg-test-task-2024/internal"
+	"pg-test-task-2024/internal/executor"
+)
 
 func main() {
+	// the executor starts itself as a shim to apply limits to the script
+	executor.RunShimIfRequested()
 	internal.Main()
 }
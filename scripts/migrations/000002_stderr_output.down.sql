@@ -0,0 +1,7 @@
+BEGIN;
+
+ALTER TABLE commands
+    DROP COLUMN stderr,
+    DROP COLUMN combined_output;
+
+COMMIT;
@@ -0,0 +1,12 @@
+BEGIN;
+
+ALTER TABLE commands
+    -- stderr of the script
+    ADD COLUMN stderr TEXT DEFAULT '',
+
+    -- stdout and stderr interleaved in the order they were read
+    ADD COLUMN combined_output TEXT DEFAULT '';
+
+UPDATE commands SET combined_output = output;
+
+COMMIT;
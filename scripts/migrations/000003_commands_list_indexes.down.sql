@@ -0,0 +1,10 @@
+BEGIN;
+
+DROP INDEX commands_signal_created_at_id_idx;
+DROP INDEX commands_exit_code_created_at_id_idx;
+DROP INDEX commands_status_created_at_id_idx;
+DROP INDEX commands_created_at_id_idx;
+
+ALTER TABLE commands DROP COLUMN created_at;
+
+COMMIT;
@@ -0,0 +1,15 @@
+BEGIN;
+
+ALTER TABLE commands
+    -- time when command was submitted
+    ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+-- indexes for listing commands ordered by (created_at, id)
+CREATE INDEX commands_created_at_id_idx ON commands (created_at, id);
+CREATE INDEX commands_status_created_at_id_idx ON commands (status, created_at, id);
+CREATE INDEX commands_exit_code_created_at_id_idx ON commands (exit_code, created_at, id)
+    WHERE exit_code IS NOT NULL;
+CREATE INDEX commands_signal_created_at_id_idx ON commands (signal, created_at, id)
+    WHERE signal IS NOT NULL;
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+
+ALTER TABLE commands
+    -- if true, stdin of the script may be written by the client
+    ADD COLUMN interactive BOOLEAN NOT NULL DEFAULT false;
+
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+
+ALTER TABLE commands DROP COLUMN timeout_ms;
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+
+ALTER TABLE commands
+    -- max duration of the command in milliseconds, 0 means no timeout
+    ADD COLUMN timeout_ms BIGINT NOT NULL DEFAULT 0;
+
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+
+ALTER TABLE commands
+    DROP COLUMN args,
+    DROP COLUMN env,
+    DROP COLUMN stdin;
+
+COMMIT;
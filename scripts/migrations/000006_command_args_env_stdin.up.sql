@@ -0,0 +1,13 @@
+BEGIN;
+
+ALTER TABLE commands
+    -- positional arguments of the script
+    ADD COLUMN args TEXT[] NOT NULL DEFAULT '{}',
+
+    -- environment variables of the script, json object
+    ADD COLUMN env JSONB NOT NULL DEFAULT '{}',
+
+    -- payload written to stdin of the script, NULL if there is no payload
+    ADD COLUMN stdin TEXT;
+
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+
+ALTER TABLE commands DROP COLUMN cancel_signal, DROP COLUMN cancel_escalated;
+
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN;
+
+ALTER TABLE commands
+    -- signal requested on cancel, NULL if the command was not canceled by the client
+    ADD COLUMN cancel_signal INTEGER,
+    -- true if the command did not exit within the grace period and was killed
+    ADD COLUMN cancel_escalated BOOLEAN NOT NULL DEFAULT false;
+
+COMMIT;
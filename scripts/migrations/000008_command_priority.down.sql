@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP INDEX commands_queued_priority_idx;
+
+ALTER TABLE commands DROP COLUMN priority;
+
+COMMIT;
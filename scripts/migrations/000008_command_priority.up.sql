@@ -0,0 +1,11 @@
+BEGIN;
+
+ALTER TABLE commands
+    -- queued commands with higher priority are started first
+    ADD COLUMN priority INTEGER NOT NULL DEFAULT 0;
+
+-- index for taking commands from the queue ordered by (priority DESC, created_at, id)
+CREATE INDEX commands_queued_priority_idx ON commands (priority DESC, created_at, id)
+    WHERE status = 'queued';
+
+COMMIT;
@@ -0,0 +1,40 @@
+BEGIN;
+
+-- output may be not valid UTF-8 (or contain NUL), such output can't be stored as text as is,
+-- so it is saved with escaped bytes instead of failing the migration
+CREATE FUNCTION pg_temp.output_to_text(data BYTEA) RETURNS TEXT AS $$
+BEGIN
+    RETURN convert_from(data, 'UTF8');
+EXCEPTION WHEN character_not_in_repertoire THEN
+    RETURN encode(data, 'escape');
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE commands
+    ADD COLUMN output TEXT DEFAULT '',
+    ADD COLUMN stderr TEXT DEFAULT '',
+    ADD COLUMN combined_output TEXT DEFAULT '';
+
+-- chunks are joined before conversion, so characters split between chunks are kept
+UPDATE commands SET
+    output = COALESCE((
+        SELECT pg_temp.output_to_text(string_agg(data, ''::BYTEA ORDER BY seq)) FROM command_output_chunks
+            WHERE command_id = commands.id AND stream = 'stdout'), ''),
+    stderr = COALESCE((
+        SELECT pg_temp.output_to_text(string_agg(data, ''::BYTEA ORDER BY seq)) FROM command_output_chunks
+            WHERE command_id = commands.id AND stream = 'stderr'), ''),
+    combined_output = COALESCE((
+        SELECT pg_temp.output_to_text(string_agg(data, ''::BYTEA ORDER BY seq)) FROM command_output_chunks
+            WHERE command_id = commands.id), '')
+    WHERE output_seq > 0;
+
+ALTER TABLE commands
+    DROP COLUMN output_seq,
+    DROP COLUMN stdout_bytes,
+    DROP COLUMN stderr_bytes;
+
+DROP TABLE command_output_chunks;
+
+DROP FUNCTION pg_temp.output_to_text(BYTEA);
+
+COMMIT;
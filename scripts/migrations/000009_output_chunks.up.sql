@@ -0,0 +1,53 @@
+BEGIN;
+
+-- output is stored as append-only chunks, so appending does not rewrite the whole output
+CREATE TABLE command_output_chunks(
+    command_id UUID NOT NULL REFERENCES commands(id) ON DELETE CASCADE,
+
+    -- order of the chunk in the output of the command, starts from 1
+    seq BIGINT NOT NULL,
+
+    -- 'stdout' | 'stderr'
+    stream TEXT NOT NULL,
+
+    -- position of the chunk in its stream in bytes
+    stream_offset BIGINT NOT NULL,
+
+    -- time when the chunk was read
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    data BYTEA NOT NULL,
+
+    PRIMARY KEY (command_id, seq)
+);
+
+ALTER TABLE commands
+    -- number of output chunks of the command
+    ADD COLUMN output_seq BIGINT NOT NULL DEFAULT 0,
+
+    -- lengths of the streams in bytes
+    ADD COLUMN stdout_bytes BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN stderr_bytes BIGINT NOT NULL DEFAULT 0;
+
+-- existing output is moved as one chunk per stream,
+-- order of interleaved stdout and stderr can't be restored
+INSERT INTO command_output_chunks (command_id, seq, stream, stream_offset, created_at, data)
+    SELECT id, 1, 'stdout', 0, created_at, convert_to(output, 'UTF8') FROM commands
+        WHERE output <> '';
+INSERT INTO command_output_chunks (command_id, seq, stream, stream_offset, created_at, data)
+    SELECT id, CASE WHEN output <> '' THEN 2 ELSE 1 END, 'stderr', 0, created_at, convert_to(stderr, 'UTF8')
+        FROM commands
+        WHERE stderr <> '';
+
+UPDATE commands SET
+    output_seq = (SELECT count(*) FROM command_output_chunks WHERE command_id = commands.id),
+    stdout_bytes = octet_length(COALESCE(output, '')),
+    stderr_bytes = octet_length(COALESCE(stderr, ''))
+    WHERE output <> '' OR stderr <> '';
+
+ALTER TABLE commands
+    DROP COLUMN output,
+    DROP COLUMN stderr,
+    DROP COLUMN combined_output;
+
+COMMIT;
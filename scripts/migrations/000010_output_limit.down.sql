@@ -0,0 +1,9 @@
+BEGIN;
+
+ALTER TABLE commands
+    DROP COLUMN output_limit,
+    DROP COLUMN output_policy,
+    DROP COLUMN output_total_bytes,
+    DROP COLUMN output_truncated;
+
+COMMIT;
@@ -0,0 +1,15 @@
+BEGIN;
+
+ALTER TABLE commands
+    -- max size of the saved output in bytes, 0 means the server limit
+    ADD COLUMN output_limit BIGINT NOT NULL DEFAULT 0,
+    -- which part of the output is saved if it exceeds the limit
+    ADD COLUMN output_policy TEXT NOT NULL DEFAULT 'head',
+    -- size of the output written by the script, including not saved part
+    ADD COLUMN output_total_bytes BIGINT NOT NULL DEFAULT 0,
+    -- true if some part of the output was not saved
+    ADD COLUMN output_truncated BOOLEAN NOT NULL DEFAULT false;
+
+UPDATE commands SET output_total_bytes = stdout_bytes + stderr_bytes;
+
+COMMIT;
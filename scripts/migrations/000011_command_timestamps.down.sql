@@ -0,0 +1,7 @@
+BEGIN;
+
+ALTER TABLE commands
+    DROP COLUMN started_at,
+    DROP COLUMN finished_at;
+
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN;
+
+ALTER TABLE commands
+    -- set when the command is taken from the queue
+    ADD COLUMN started_at TIMESTAMPTZ,
+    -- set when the command gets final status
+    ADD COLUMN finished_at TIMESTAMPTZ;
+
+COMMIT;
@@ -0,0 +1,12 @@
+BEGIN;
+
+ALTER TABLE commands
+    DROP COLUMN user_cpu_us,
+    DROP COLUMN system_cpu_us,
+    DROP COLUMN max_rss_kb,
+    DROP COLUMN in_blocks,
+    DROP COLUMN out_blocks,
+    DROP COLUMN voluntary_ctx_switches,
+    DROP COLUMN involuntary_ctx_switches;
+
+COMMIT;
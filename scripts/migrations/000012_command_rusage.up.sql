@@ -0,0 +1,13 @@
+BEGIN;
+
+-- resource usage of the script, set when it exits
+ALTER TABLE commands
+    ADD COLUMN user_cpu_us BIGINT,
+    ADD COLUMN system_cpu_us BIGINT,
+    ADD COLUMN max_rss_kb BIGINT,
+    ADD COLUMN in_blocks BIGINT,
+    ADD COLUMN out_blocks BIGINT,
+    ADD COLUMN voluntary_ctx_switches BIGINT,
+    ADD COLUMN involuntary_ctx_switches BIGINT;
+
+COMMIT;
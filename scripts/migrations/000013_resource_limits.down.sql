@@ -0,0 +1,10 @@
+BEGIN;
+
+ALTER TABLE commands
+    DROP COLUMN memory_limit,
+    DROP COLUMN cpu_limit,
+    DROP COLUMN pids_limit,
+    DROP COLUMN nofile_limit,
+    DROP COLUMN fsize_limit;
+
+COMMIT;
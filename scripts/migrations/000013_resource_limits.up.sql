@@ -0,0 +1,12 @@
+BEGIN;
+
+-- resource limits requested on submission, 0 means the server limit.
+-- memory and file size are in bytes, cpu is a number of cores
+ALTER TABLE commands
+    ADD COLUMN memory_limit BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN cpu_limit DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN pids_limit BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN nofile_limit BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN fsize_limit BIGINT NOT NULL DEFAULT 0;
+
+COMMIT;
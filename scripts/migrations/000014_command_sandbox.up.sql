@@ -0,0 +1,7 @@
+BEGIN;
+
+-- true if the script is executed in the sandbox with its own namespaces
+ALTER TABLE commands
+    ADD COLUMN sandbox BOOLEAN NOT NULL DEFAULT false;
+
+COMMIT;
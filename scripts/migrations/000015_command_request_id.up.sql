@@ -0,0 +1,7 @@
+BEGIN;
+
+-- id of the request which submitted the command, passed to the script
+ALTER TABLE commands
+    ADD COLUMN request_id TEXT NOT NULL DEFAULT '';
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE commands
+    DROP COLUMN workspace;
+
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+
+-- working directory of the script, set when the command is started.
+-- The directory is removed after the retention period, the path is kept
+ALTER TABLE commands
+    ADD COLUMN workspace TEXT;
+
+COMMIT;
@@ -0,0 +1,22 @@
+BEGIN;
+
+-- files collected from the artifacts directory of the script after it exits,
+-- content is stored on disk of the server
+CREATE TABLE command_artifacts(
+    command_id UUID NOT NULL REFERENCES commands(id) ON DELETE CASCADE,
+
+    -- name of the file in the artifacts directory
+    name TEXT NOT NULL,
+
+    -- size in bytes
+    size BIGINT NOT NULL,
+
+    -- hex encoded SHA-256 of the content
+    sha256 TEXT NOT NULL,
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY (command_id, name)
+);
+
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+
+-- chunks of the tail of the output kept by the limiter. They are saved while the script
+-- is running and replaced with the new tail on each flush
+ALTER TABLE command_output_chunks
+    ADD COLUMN tail BOOLEAN NOT NULL DEFAULT false;
+
+COMMIT;
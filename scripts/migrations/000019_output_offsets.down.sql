@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE command_output_chunks
+    DROP COLUMN output_offset;
+
+COMMIT;
@@ -0,0 +1,20 @@
+BEGIN;
+
+-- position of the chunk in the combined output of stdout and stderr in bytes.
+-- Lengths of the streams only grow, even if the tail of the output is replaced,
+-- so offsets of the chunks do not change and readers may continue from the returned offset
+ALTER TABLE command_output_chunks
+    ADD COLUMN output_offset BIGINT;
+
+UPDATE command_output_chunks AS c SET output_offset = o.output_offset
+    FROM (
+        SELECT command_id, seq,
+                SUM(length(data)) OVER (PARTITION BY command_id ORDER BY seq) - length(data) AS output_offset
+            FROM command_output_chunks
+    ) AS o
+    WHERE c.command_id = o.command_id AND c.seq = o.seq;
+
+ALTER TABLE command_output_chunks
+    ALTER COLUMN output_offset SET NOT NULL;
+
+COMMIT;